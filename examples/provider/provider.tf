@@ -15,6 +15,11 @@ variable "project_id" {
   type = string
 }
 
+variable "base_url" {
+  type    = string
+  default = "" // gitlab.com
+}
+
 variable "files" {
   type = list(string) // a list of file paths
 }
@@ -22,6 +27,7 @@ variable "files" {
 provider "gitlabcommit" {
   gitlab_api_token = var.gitlab_api_token
   project_id       = var.project_id
+  base_url         = var.base_url
   branch           = "main"
   author_email     = "akselleirv@example.com"
   author_name      = "Aksel"
@@ -3,6 +3,7 @@ package test
 import (
 	"encoding/base64"
 	"fmt"
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
@@ -18,18 +19,19 @@ import (
 
 func TestResourceWithForEach(t *testing.T) {
 	filePaths := mockFilePaths()
-	token, projectId := mustGetEnv(t, "GITLAB_TOKEN"), mustGetEnv(t, "PROJECT_ID")
+	token, projectId, baseURL := gitlabProject(t)
 	opts := &terraform.Options{
 		TerraformDir: "../",
 		Vars: map[string]interface{}{
-			"files": filePaths,
+			"files":    filePaths,
+			"base_url": baseURL,
 		},
 		EnvVars: map[string]string{
 			"TF_VAR_gitlab_api_token": token,
 			"TF_VAR_project_id":       projectId,
 		},
 	}
-	c, err := gitlab.NewClient(token)
+	c, err := gitlab.NewClient(token, gitlab.WithBaseURL(baseURL))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -43,6 +45,17 @@ func TestResourceWithForEach(t *testing.T) {
 	validateFilesExist(t, filePaths, c, projectId)
 }
 
+// gitlabProject returns the GitLab project to test against, an in-memory fake GitLab is started if GITLAB_TOKEN is not set
+func gitlabProject(t *testing.T) (token, projectId, baseURL string) {
+	if os.Getenv("GITLAB_TOKEN") != "" {
+		return os.Getenv("GITLAB_TOKEN"), mustGetEnv(t, "PROJECT_ID"), os.Getenv("GITLAB_BASE_URL")
+	}
+
+	server := gitlabfake.NewServer()
+	t.Cleanup(server.Close)
+	return "token", "1", server.URL
+}
+
 func validateFilesExist(t *testing.T, paths []string, c *gitlab.Client, projectId string) {
 	for _, path := range paths {
 		file, _, err := c.RepositoryFiles.GetFile(projectId, path, &gitlab.GetFileOptions{Ref: gitlab.String("main")})
@@ -0,0 +1,6 @@
+resource "gitlabcommit_directory" "example" {
+  source_dir = "${path.module}/config"
+  target_dir = "config"
+  include    = ["**/*.yaml"]
+  exclude    = ["**/tmp/**"]
+}
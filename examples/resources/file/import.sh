@@ -0,0 +1,5 @@
+# Import a file from the project and branch configured in the provider
+terraform import gitlabcommit_file.example dir/file-1.txt
+
+# Import a file from another project and branch with <project>:<branch>:<path>
+terraform import gitlabcommit_file.example group/project:develop:dir/file-1.txt
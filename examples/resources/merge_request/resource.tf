@@ -0,0 +1,17 @@
+resource "gitlabcommit_file" "version" {
+  file_path = "VERSION"
+  content   = "1.2.3"
+  branch    = "release-1.2.3"
+}
+
+resource "gitlabcommit_merge_request" "release" {
+  source_branch                = "release-1.2.3"
+  target_branch                = "main"
+  title                        = "Release 1.2.3"
+  merge_when_pipeline_succeeds = true
+  wait_for_merge               = true
+
+  timeouts {
+    create = "1h"
+  }
+}
@@ -0,0 +1,206 @@
+package gitlabfake
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// mergeRequest is a merge request of a project. There are no pipelines, so merge when pipeline succeeds merges immediately.
+type mergeRequest struct {
+	gitlab.MergeRequest
+
+	removeSourceBranch bool
+}
+
+func (p *project) listMergeRequestsHandler(r *http.Request) (interface{}, *apiError) {
+	query := r.URL.Query()
+
+	mergeRequests := []*gitlab.MergeRequest{}
+	for _, mr := range p.mergeRequests {
+		if (query.Get("state") != "" && query.Get("state") != "all" && query.Get("state") != mr.State) ||
+			(query.Get("source_branch") != "" && query.Get("source_branch") != mr.SourceBranch) ||
+			(query.Get("target_branch") != "" && query.Get("target_branch") != mr.TargetBranch) {
+			continue
+		}
+		mergeRequests = append(mergeRequests, p.refresh(mr))
+	}
+	return mergeRequests, nil
+}
+
+func (p *project) createMergeRequestHandler(r *http.Request) (interface{}, *apiError) {
+	opts := &gitlab.CreateMergeRequestOptions{}
+	if err := decodeBody(r, opts); err != nil {
+		return nil, err
+	}
+	if opts.SourceBranch == nil || opts.TargetBranch == nil || opts.Title == nil {
+		return nil, errorf(http.StatusBadRequest, "source_branch, target_branch and title are required")
+	}
+	if _, ok := p.branches[*opts.SourceBranch]; !ok {
+		return nil, errorf(http.StatusNotFound, "404 Source branch Not Found")
+	}
+	if _, ok := p.branches[*opts.TargetBranch]; !ok {
+		return nil, errorf(http.StatusNotFound, "404 Target branch Not Found")
+	}
+	for _, mr := range p.mergeRequests {
+		if mr.State == "opened" && mr.SourceBranch == *opts.SourceBranch && mr.TargetBranch == *opts.TargetBranch {
+			return nil, errorf(http.StatusConflict, "Another open merge request already exists for this source branch: !%d", mr.IID)
+		}
+	}
+
+	now := time.Now().UTC()
+	iid := len(p.mergeRequests) + 1
+	mr := &mergeRequest{
+		MergeRequest: gitlab.MergeRequest{
+			ID:           iid,
+			IID:          iid,
+			Title:        *opts.Title,
+			State:        "opened",
+			SourceBranch: *opts.SourceBranch,
+			TargetBranch: *opts.TargetBranch,
+			WebURL:       p.webURL + "/-/merge_requests/" + strconv.Itoa(iid),
+			CreatedAt:    &now,
+			UpdatedAt:    &now,
+		},
+	}
+	if opts.Description != nil {
+		mr.Description = *opts.Description
+	}
+	if opts.RemoveSourceBranch != nil {
+		mr.removeSourceBranch = *opts.RemoveSourceBranch
+	}
+	if opts.Labels != nil {
+		mr.Labels = opts.Labels
+	}
+	p.mergeRequests = append(p.mergeRequests, mr)
+	return p.refresh(mr), nil
+}
+
+func (p *project) getMergeRequestHandler(iid string) (interface{}, *apiError) {
+	mr, err := p.mergeRequest(iid)
+	if err != nil {
+		return nil, err
+	}
+	return p.refresh(mr), nil
+}
+
+func (p *project) updateMergeRequestHandler(r *http.Request, iid string) (interface{}, *apiError) {
+	mr, err := p.mergeRequest(iid)
+	if err != nil {
+		return nil, err
+	}
+	opts := &gitlab.UpdateMergeRequestOptions{}
+	if err := decodeBody(r, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.Title != nil {
+		mr.Title = *opts.Title
+	}
+	if opts.Description != nil {
+		mr.Description = *opts.Description
+	}
+	if opts.RemoveSourceBranch != nil {
+		mr.removeSourceBranch = *opts.RemoveSourceBranch
+	}
+	if opts.StateEvent != nil {
+		switch {
+		case *opts.StateEvent == "close" && mr.State == "opened":
+			mr.State = "closed"
+		case *opts.StateEvent == "reopen" && mr.State == "closed":
+			mr.State = "opened"
+		}
+	}
+	now := time.Now().UTC()
+	mr.UpdatedAt = &now
+	return p.refresh(mr), nil
+}
+
+func (p *project) acceptMergeRequestHandler(r *http.Request, iid string) (interface{}, *apiError) {
+	mr, err := p.mergeRequest(iid)
+	if err != nil {
+		return nil, err
+	}
+	opts := &gitlab.AcceptMergeRequestOptions{}
+	if err := decodeBody(r, opts); err != nil {
+		return nil, err
+	}
+
+	if mr.State != "opened" {
+		return nil, errorf(http.StatusMethodNotAllowed, "405 Method Not Allowed")
+	}
+	source, ok := p.branches[mr.SourceBranch]
+	if !ok {
+		return nil, errorf(http.StatusMethodNotAllowed, "405 Method Not Allowed")
+	}
+	if opts.SHA != nil && *opts.SHA != source {
+		return nil, errorf(http.StatusConflict, "SHA does not match HEAD of source branch: %s", source)
+	}
+	target := p.branches[mr.TargetBranch]
+	if !p.isAncestor(target, source) {
+		return nil, errorf(http.StatusNotAcceptable, "Branch cannot be merged")
+	}
+
+	merge := p.newCommit(
+		[]string{target, source},
+		fmt.Sprintf("Merge branch '%s' into '%s'\n\n%s\n\nSee merge request !%d", mr.SourceBranch, mr.TargetBranch, mr.Title, mr.IID),
+		"Administrator", "admin@example.com",
+		p.commits[source].files,
+	)
+	p.branches[mr.TargetBranch] = merge.ID
+
+	removeSourceBranch := mr.removeSourceBranch
+	if opts.ShouldRemoveSourceBranch != nil {
+		removeSourceBranch = *opts.ShouldRemoveSourceBranch
+	}
+	if removeSourceBranch {
+		delete(p.branches, mr.SourceBranch)
+	}
+
+	now := time.Now().UTC()
+	mr.State = "merged"
+	mr.SHA = source
+	mr.MergeCommitSHA = merge.ID
+	mr.MergedAt = &now
+	mr.UpdatedAt = &now
+	return p.refresh(mr), nil
+}
+
+func (p *project) mergeRequest(iid string) (*mergeRequest, *apiError) {
+	n, err := strconv.Atoi(iid)
+	if err != nil || n < 1 || n > len(p.mergeRequests) {
+		return nil, errorf(http.StatusNotFound, "404 Not found")
+	}
+	return p.mergeRequests[n-1], nil
+}
+
+// refresh returns the merge request with the SHA of the source branch while it is open
+func (p *project) refresh(mr *mergeRequest) *gitlab.MergeRequest {
+	if mr.State == "opened" {
+		mr.SHA = p.branches[mr.SourceBranch]
+	}
+	mr.ForceRemoveSourceBranch = mr.removeSourceBranch
+	v := mr.MergeRequest
+	return &v
+}
+
+// isAncestor returns whether the commit ancestor is reachable from the commit id
+func (p *project) isAncestor(ancestor, id string) bool {
+	pending := []string{id}
+	seen := map[string]bool{}
+	for len(pending) > 0 {
+		id, pending = pending[0], pending[1:]
+		if id == ancestor {
+			return true
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		pending = append(pending, p.commits[id].ParentIDs...)
+	}
+	return false
+}
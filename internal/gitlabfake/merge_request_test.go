@@ -0,0 +1,101 @@
+package gitlabfake
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestMergeRequest(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	s.WriteFile("1", DefaultBranch, "file.txt", []byte("old"))
+	source := s.WriteFile("1", "feature", "file.txt", []byte("new"))
+
+	mr, _, err := c.MergeRequests.CreateMergeRequest("1", &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.String("Change file"),
+		SourceBranch:       gitlab.String("feature"),
+		TargetBranch:       gitlab.String(DefaultBranch),
+		RemoveSourceBranch: gitlab.Bool(true),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, mr.IID)
+	assert.Equal(t, "opened", mr.State)
+	assert.Equal(t, source, mr.SHA)
+
+	_, resp, err := c.MergeRequests.CreateMergeRequest("1", &gitlab.CreateMergeRequestOptions{
+		Title:        gitlab.String("Change file again"),
+		SourceBranch: gitlab.String("feature"),
+		TargetBranch: gitlab.String(DefaultBranch),
+	})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	opened, _, err := c.MergeRequests.ListProjectMergeRequests("1", &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		SourceBranch: gitlab.String("feature"),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, opened, 1)
+
+	mr, _, err = c.MergeRequests.UpdateMergeRequest("1", mr.IID, &gitlab.UpdateMergeRequestOptions{Title: gitlab.String("Update file")})
+	assert.NoError(t, err)
+	assert.Equal(t, "Update file", mr.Title)
+
+	_, resp, err = c.MergeRequests.AcceptMergeRequest("1", mr.IID, &gitlab.AcceptMergeRequestOptions{SHA: gitlab.String("other")})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// merge when pipeline succeeds merges immediately without pipelines
+	mr, _, err = c.MergeRequests.AcceptMergeRequest("1", mr.IID, &gitlab.AcceptMergeRequestOptions{
+		SHA:                       gitlab.String(source),
+		MergeWhenPipelineSucceeds: gitlab.Bool(true),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "merged", mr.State)
+
+	content, _ := s.File("1", DefaultBranch, "file.txt")
+	assert.Equal(t, "new", string(content))
+	head, _ := s.Branch("1", DefaultBranch)
+	assert.Equal(t, head, mr.MergeCommitSHA)
+	_, ok := s.Branch("1", "feature")
+	assert.False(t, ok, "the source branch should be removed")
+
+	mr, _, err = c.MergeRequests.GetMergeRequest("1", mr.IID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "merged", mr.State)
+	assert.Equal(t, source, mr.SHA)
+}
+
+func TestMergeRequestClose(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	s.WriteFile("1", "feature", "file.txt", []byte("new"))
+	// the target branch has changed since the source branch was created
+	s.WriteFile("1", DefaultBranch, "other.txt", []byte("other"))
+
+	mr, _, err := c.MergeRequests.CreateMergeRequest("1", &gitlab.CreateMergeRequestOptions{
+		Title:        gitlab.String("Change file"),
+		SourceBranch: gitlab.String("feature"),
+		TargetBranch: gitlab.String(DefaultBranch),
+	})
+	assert.NoError(t, err)
+
+	_, resp, err := c.MergeRequests.AcceptMergeRequest("1", mr.IID, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+
+	mr, _, err = c.MergeRequests.UpdateMergeRequest("1", mr.IID, &gitlab.UpdateMergeRequestOptions{StateEvent: gitlab.String("close")})
+	assert.NoError(t, err)
+	assert.Equal(t, "closed", mr.State)
+
+	_, resp, err = c.MergeRequests.GetMergeRequest("1", 2, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,533 @@
+package gitlabfake
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// project is the repository and merge requests of a project
+type project struct {
+	id string
+
+	// webURL is the URL of the project used in the web URLs of commits and merge requests
+	webURL string
+
+	// branches are the head commit IDs by branch name
+	branches map[string]string
+
+	commits map[string]*commit
+
+	mergeRequests []*mergeRequest
+}
+
+// commit is a commit with a snapshot of all files
+type commit struct {
+	gitlab.Commit
+
+	files map[string]*file
+}
+
+type file struct {
+	content []byte
+
+	// blobID is the git blob ID of the content
+	blobID string
+
+	// lastCommitID is the last commit that changed the file
+	lastCommitID string
+}
+
+func newProject(id, serverURL string) *project {
+	p := &project{
+		id:       id,
+		webURL:   serverURL + "/" + id,
+		branches: map[string]string{},
+		commits:  map[string]*commit{},
+	}
+
+	initial := p.newCommit(nil, "Initial commit", "Administrator", "admin@example.com", map[string]*file{})
+	p.branches[DefaultBranch] = initial.ID
+	return p
+}
+
+// newCommit stores a commit with the files, the ID is derived from the parents, message and the number of commits
+func (p *project) newCommit(parents []string, message, authorName, authorEmail string, files map[string]*file) *commit {
+	id := hex.EncodeToString(sha1Sum(fmt.Sprintf("%s\n%s\n%s\n%d", p.id, strings.Join(parents, " "), message, len(p.commits))))
+	now := time.Now().UTC()
+	title := strings.SplitN(message, "\n", 2)[0]
+
+	c := &commit{
+		Commit: gitlab.Commit{
+			ID:             id,
+			ShortID:        id[:8],
+			Title:          title,
+			Message:        message,
+			AuthorName:     authorName,
+			AuthorEmail:    authorEmail,
+			AuthoredDate:   &now,
+			CommitterName:  authorName,
+			CommitterEmail: authorEmail,
+			CommittedDate:  &now,
+			CreatedAt:      &now,
+			ParentIDs:      parents,
+			WebURL:         p.webURL + "/-/commit/" + id,
+		},
+		files: files,
+	}
+	if c.ParentIDs == nil {
+		c.ParentIDs = []string{}
+	}
+	p.commits[id] = c
+	return c
+}
+
+// resolve returns the commit of a branch or commit ID
+func (p *project) resolve(ref string) (*commit, bool) {
+	if id, ok := p.branches[ref]; ok {
+		ref = id
+	}
+	c, ok := p.commits[ref]
+	return c, ok
+}
+
+// commitActions creates a commit on the branch, like the GitLab commits API
+func (p *project) commitActions(opts *gitlab.CreateCommitOptions) (*commit, *apiError) {
+	if opts.Branch == nil || *opts.Branch == "" {
+		return nil, errorf(http.StatusBadRequest, "branch is missing")
+	}
+	if len(opts.Actions) == 0 {
+		return nil, errorf(http.StatusBadRequest, "actions is missing")
+	}
+
+	branch := *opts.Branch
+	head, exists := p.branches[branch]
+	parent := head
+	switch {
+	case opts.StartSHA != nil:
+		// like start_branch, start_sha is only used to create a new branch
+		if exists {
+			return nil, errorf(http.StatusBadRequest, "A branch called '%s' already exists. Switch to that branch in order to make changes", branch)
+		}
+		if _, ok := p.commits[*opts.StartSHA]; !ok {
+			return nil, errorf(http.StatusBadRequest, "Invalid start SHA %s", *opts.StartSHA)
+		}
+		parent = *opts.StartSHA
+	case opts.StartBranch != nil && *opts.StartBranch != branch:
+		if exists {
+			return nil, errorf(http.StatusBadRequest, "A branch called '%s' already exists. Switch to that branch in order to make changes", branch)
+		}
+		start, ok := p.branches[*opts.StartBranch]
+		if !ok {
+			return nil, errorf(http.StatusBadRequest, "You can only create or edit files when you are on a branch")
+		}
+		parent = start
+	case !exists:
+		return nil, errorf(http.StatusBadRequest, "You can only create or edit files when you are on a branch")
+	}
+
+	files := map[string]*file{}
+	for filePath, f := range p.commits[parent].files {
+		files[filePath] = f
+	}
+
+	// the files are changed with a placeholder for the last commit, since the ID is not known before the commit is created
+	const placeholder = "\x00"
+	for _, action := range opts.Actions {
+		if err := applyAction(files, action, placeholder); err != nil {
+			return nil, err
+		}
+	}
+
+	authorName, authorEmail := "Administrator", "admin@example.com"
+	if opts.AuthorName != nil && *opts.AuthorName != "" {
+		authorName = *opts.AuthorName
+	}
+	if opts.AuthorEmail != nil && *opts.AuthorEmail != "" {
+		authorEmail = *opts.AuthorEmail
+	}
+	var message string
+	if opts.CommitMessage != nil {
+		message = *opts.CommitMessage
+	}
+
+	c := p.newCommit([]string{parent}, message, authorName, authorEmail, files)
+	for filePath, f := range files {
+		if f.lastCommitID == placeholder {
+			files[filePath] = &file{content: f.content, blobID: f.blobID, lastCommitID: c.ID}
+		}
+	}
+	p.branches[branch] = c.ID
+	return c, nil
+}
+
+// applyAction changes the files of the commit being created, a file that is changed gets lastCommitID
+func applyAction(files map[string]*file, action *gitlab.CommitActionOptions, lastCommitID string) *apiError {
+	if action.Action == nil || action.FilePath == nil {
+		return errorf(http.StatusBadRequest, "action and file_path are required")
+	}
+	filePath := *action.FilePath
+	existing, exists := files[filePath]
+
+	content := func() ([]byte, *apiError) {
+		if action.Content == nil {
+			return nil, nil
+		}
+		if action.Encoding != nil && *action.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(*action.Content)
+			if err != nil {
+				return nil, errorf(http.StatusBadRequest, "invalid base64 content of %s", filePath)
+			}
+			return decoded, nil
+		}
+		return []byte(*action.Content), nil
+	}
+	checkLastCommit := func(f *file) *apiError {
+		if action.LastCommitID != nil && *action.LastCommitID != f.lastCommitID {
+			return errorf(http.StatusBadRequest, "You are attempting to update a file that has changed since you started editing it.")
+		}
+		return nil
+	}
+
+	switch *action.Action {
+	case gitlab.FileCreate:
+		if exists {
+			return errorf(http.StatusBadRequest, "A file with this name already exists")
+		}
+		c, err := content()
+		if err != nil {
+			return err
+		}
+		files[filePath] = newFile(c, lastCommitID)
+	case gitlab.FileUpdate:
+		if !exists {
+			return errorf(http.StatusBadRequest, "A file with this name doesn't exist")
+		}
+		if err := checkLastCommit(existing); err != nil {
+			return err
+		}
+		c, err := content()
+		if err != nil {
+			return err
+		}
+		files[filePath] = newFile(c, lastCommitID)
+	case gitlab.FileDelete:
+		if !exists {
+			return errorf(http.StatusBadRequest, "A file with this name doesn't exist")
+		}
+		if err := checkLastCommit(existing); err != nil {
+			return err
+		}
+		delete(files, filePath)
+	case gitlab.FileMove:
+		if action.PreviousPath == nil {
+			return errorf(http.StatusBadRequest, "previous_path is required to move %s", filePath)
+		}
+		previous, ok := files[*action.PreviousPath]
+		if !ok {
+			return errorf(http.StatusBadRequest, "A file with this name doesn't exist")
+		}
+		if exists {
+			return errorf(http.StatusBadRequest, "A file with this name already exists")
+		}
+		if err := checkLastCommit(previous); err != nil {
+			return err
+		}
+		c, err := content()
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			c = previous.content
+		}
+		delete(files, *action.PreviousPath)
+		files[filePath] = newFile(c, lastCommitID)
+	case gitlab.FileChmod:
+		if !exists {
+			return errorf(http.StatusBadRequest, "A file with this name doesn't exist")
+		}
+	default:
+		return errorf(http.StatusBadRequest, "unknown action %s", *action.Action)
+	}
+	return nil
+}
+
+func newFile(content []byte, lastCommitID string) *file {
+	if content == nil {
+		content = []byte{}
+	}
+	return &file{content: content, blobID: BlobID(content), lastCommitID: lastCommitID}
+}
+
+// BlobID returns the git blob ID of the content, which GitLab returns as the blob_id of files and the id of tree entries
+func BlobID(content []byte) string {
+	return hex.EncodeToString(sha1Sum(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+}
+
+func sha1Sum(s string) []byte {
+	sum := sha1.Sum([]byte(s))
+	return sum[:]
+}
+
+func (p *project) createCommitHandler(r *http.Request) (interface{}, *apiError) {
+	opts := &gitlab.CreateCommitOptions{}
+	if err := decodeBody(r, opts); err != nil {
+		return nil, err
+	}
+	c, err := p.commitActions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.Commit, nil
+}
+
+func (p *project) getCommitHandler(sha string) (interface{}, *apiError) {
+	c, ok := p.resolve(sha)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "404 Commit Not Found")
+	}
+	return c.Commit, nil
+}
+
+func (p *project) getFileHandler(w http.ResponseWriter, r *http.Request, filePath string) (interface{}, *apiError) {
+	ref := r.URL.Query().Get("ref")
+	c, ok := p.resolve(ref)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "404 Commit Not Found")
+	}
+	f, ok := c.files[filePath]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "404 File Not Found")
+	}
+
+	sum := sha256.Sum256(f.content)
+	file := gitlab.File{
+		FileName:     path.Base(filePath),
+		FilePath:     filePath,
+		Size:         len(f.content),
+		Encoding:     "base64",
+		Content:      base64.StdEncoding.EncodeToString(f.content),
+		Ref:          ref,
+		BlobID:       f.blobID,
+		CommitID:     c.ID,
+		SHA256:       hex.EncodeToString(sum[:]),
+		LastCommitID: f.lastCommitID,
+	}
+	if r.Method == http.MethodGet {
+		return file, nil
+	}
+
+	// the metadata is returned in the headers of a HEAD request
+	for key, value := range map[string]string{
+		"X-Gitlab-Blob-Id":        file.BlobID,
+		"X-Gitlab-Commit-Id":      file.CommitID,
+		"X-Gitlab-Content-Sha256": file.SHA256,
+		"X-Gitlab-Encoding":       file.Encoding,
+		"X-Gitlab-File-Name":      file.FileName,
+		"X-Gitlab-File-Path":      file.FilePath,
+		"X-Gitlab-Last-Commit-Id": file.LastCommitID,
+		"X-Gitlab-Ref":            file.Ref,
+		"X-Gitlab-Size":           strconv.Itoa(file.Size),
+	} {
+		w.Header().Set(key, value)
+	}
+	return nil, nil
+}
+
+func (p *project) listTreeHandler(w http.ResponseWriter, r *http.Request) (interface{}, *apiError) {
+	query := r.URL.Query()
+	ref := query.Get("ref")
+	if ref == "" {
+		ref = DefaultBranch
+	}
+	c, ok := p.resolve(ref)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "404 Tree Not Found")
+	}
+
+	dir := strings.Trim(query.Get("path"), "/")
+	recursive := query.Get("recursive") == "true"
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	nodes := map[string]*gitlab.TreeNode{}
+	for filePath, f := range c.files {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(filePath, prefix), "/")
+		// the directories between dir and the file are trees
+		for i := 1; i < len(parts); i++ {
+			if !recursive && i > 1 {
+				break
+			}
+			treePath := prefix + strings.Join(parts[:i], "/")
+			nodes[treePath] = &gitlab.TreeNode{ID: BlobID([]byte(treePath)), Name: parts[i-1], Type: "tree", Path: treePath, Mode: "040000"}
+		}
+		if recursive || len(parts) == 1 {
+			nodes[filePath] = &gitlab.TreeNode{ID: f.blobID, Name: path.Base(filePath), Type: "blob", Path: filePath, Mode: "100644"}
+		}
+	}
+	if dir != "" && len(nodes) == 0 {
+		return nil, errorf(http.StatusNotFound, "404 Tree Not Found")
+	}
+
+	var sorted []*gitlab.TreeNode
+	for _, node := range nodes {
+		sorted = append(sorted, node)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	return paginate(w, r, sorted), nil
+}
+
+// paginate returns the page of the nodes requested with page and per_page, setting the pagination headers used by go-gitlab
+func paginate(w http.ResponseWriter, r *http.Request, nodes []*gitlab.TreeNode) []*gitlab.TreeNode {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 20
+	}
+
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(nodes) {
+		start = len(nodes)
+	}
+	if end >= len(nodes) {
+		end = len(nodes)
+	} else {
+		w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+	w.Header().Set("X-Page", strconv.Itoa(page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	w.Header().Set("X-Total", strconv.Itoa(len(nodes)))
+
+	result := nodes[start:end]
+	if result == nil {
+		result = []*gitlab.TreeNode{}
+	}
+	return result
+}
+
+func (p *project) getBranchHandler(name string) (interface{}, *apiError) {
+	id, ok := p.branches[name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "404 Branch Not Found")
+	}
+	c := p.commits[id].Commit
+	return gitlab.Branch{Name: name, Commit: &c, Default: name == DefaultBranch, WebURL: p.webURL + "/-/tree/" + name}, nil
+}
+
+// WriteFile commits the content to the branch as a change made outside of the provider, the branch is created from DefaultBranch if it does not exist
+func (s *Server) WriteFile(projectId, branch, filePath string, content []byte) string {
+	return s.commitOutside(projectId, branch, &gitlab.CommitActionOptions{FilePath: gitlab.String(filePath), Content: gitlab.String(string(content))})
+}
+
+// DeleteFile commits the deletion of the file to the branch as a change made outside of the provider
+func (s *Server) DeleteFile(projectId, branch, filePath string) string {
+	return s.commitOutside(projectId, branch, &gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileDelete), FilePath: gitlab.String(filePath)})
+}
+
+func (s *Server) commitOutside(projectId, branch string, action *gitlab.CommitActionOptions) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.project(projectId)
+	opts := &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(branch),
+		CommitMessage: gitlab.String("Change " + *action.FilePath + " outside of Terraform"),
+		Actions:       []*gitlab.CommitActionOptions{action},
+	}
+	ref := branch
+	if _, ok := p.branches[branch]; !ok {
+		opts.StartBranch = gitlab.String(DefaultBranch)
+		ref = DefaultBranch
+	}
+	if action.Action == nil {
+		action.Action = gitlab.FileAction(gitlab.FileCreate)
+		if c, _ := p.resolve(ref); c.files[*action.FilePath] != nil {
+			action.Action = gitlab.FileAction(gitlab.FileUpdate)
+		}
+	}
+
+	c, err := p.commitActions(opts)
+	if err != nil {
+		panic(fmt.Sprintf("gitlabfake: unable to commit %s: %s", *action.FilePath, err.message))
+	}
+	return c.ID
+}
+
+// File returns the content of the file on the branch or commit
+func (s *Server) File(projectId, ref, filePath string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.project(projectId).resolve(ref)
+	if !ok {
+		return nil, false
+	}
+	f, ok := c.files[filePath]
+	if !ok {
+		return nil, false
+	}
+	return f.content, true
+}
+
+// Files returns the paths of the files on the branch or commit, sorted
+func (s *Server) Files(projectId, ref string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.project(projectId).resolve(ref)
+	if !ok {
+		return nil
+	}
+	var paths []string
+	for filePath := range c.files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Commits returns the commits of the branch from the newest, the initial commit of the project is included
+func (s *Server) Commits(projectId, branch string) []gitlab.Commit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.project(projectId)
+	var commits []gitlab.Commit
+	for id, ok := p.branches[branch]; ok; {
+		c := p.commits[id]
+		commits = append(commits, c.Commit)
+		if len(c.ParentIDs) == 0 {
+			break
+		}
+		id = c.ParentIDs[0]
+	}
+	return commits
+}
+
+// Branch returns the head commit ID of the branch
+func (s *Server) Branch(projectId, branch string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.project(projectId).branches[branch]
+	return id, ok
+}
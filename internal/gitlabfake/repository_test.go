@@ -0,0 +1,203 @@
+package gitlabfake
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestCreateCommit(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	commit, _, err := c.Commits.CreateCommit("1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(DefaultBranch),
+		CommitMessage: gitlab.String("Add files\n\nbody"),
+		AuthorName:    gitlab.String("Terraform"),
+		Actions: []*gitlab.CommitActionOptions{
+			{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("a.txt"), Content: gitlab.String("a")},
+			{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("b.bin"), Content: gitlab.String("AAE="), Encoding: gitlab.String("base64")},
+			{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("c.txt"), Content: gitlab.String("c")},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Add files", commit.Title)
+	assert.Equal(t, "Terraform", commit.AuthorName)
+
+	_, _, err = c.Commits.CreateCommit("1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(DefaultBranch),
+		CommitMessage: gitlab.String("Change files"),
+		Actions: []*gitlab.CommitActionOptions{
+			{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("a.txt"), Content: gitlab.String("changed"), LastCommitID: gitlab.String(commit.ID)},
+			{Action: gitlab.FileAction(gitlab.FileMove), FilePath: gitlab.String("dir/c.txt"), PreviousPath: gitlab.String("c.txt")},
+			{Action: gitlab.FileAction(gitlab.FileDelete), FilePath: gitlab.String("b.bin")},
+		},
+	})
+	assert.NoError(t, err)
+
+	content, ok := s.File("1", DefaultBranch, "a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "changed", string(content))
+	content, ok = s.File("1", commit.ID, "b.bin")
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0, 1}, content)
+	assert.Equal(t, []string{"a.txt", "dir/c.txt"}, s.Files("1", DefaultBranch))
+	assert.Len(t, s.Commits("1", DefaultBranch), 3)
+
+	got, _, err := c.Commits.GetCommit("1", commit.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, commit.ID, got.ID)
+}
+
+func TestCreateCommitErrors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	first := s.WriteFile("1", DefaultBranch, "file.txt", []byte("first"))
+	s.WriteFile("1", DefaultBranch, "file.txt", []byte("second"))
+
+	tests := []struct {
+		name            string
+		opts            *gitlab.CreateCommitOptions
+		expectedMessage string
+	}{
+		{
+			name:            "create existing file",
+			opts:            &gitlab.CreateCommitOptions{Actions: []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt")}}},
+			expectedMessage: "A file with this name already exists",
+		},
+		{
+			name:            "update missing file",
+			opts:            &gitlab.CreateCommitOptions{Actions: []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("missing.txt")}}},
+			expectedMessage: "A file with this name doesn't exist",
+		},
+		{
+			name:            "update file changed since last commit",
+			opts:            &gitlab.CreateCommitOptions{Actions: []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("file.txt"), LastCommitID: gitlab.String(first)}}},
+			expectedMessage: "You are attempting to update a file that has changed since you started editing it.",
+		},
+		{
+			name:            "start SHA on existing branch",
+			opts:            &gitlab.CreateCommitOptions{StartSHA: gitlab.String(first), Actions: []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("other.txt")}}},
+			expectedMessage: "A branch called 'main' already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Branch = gitlab.String(DefaultBranch)
+			tt.opts.CommitMessage = gitlab.String(tt.name)
+
+			_, resp, err := c.Commits.CreateCommit("1", tt.opts)
+			assert.Error(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Contains(t, err.Error(), tt.expectedMessage)
+		})
+	}
+	assert.Len(t, s.Commits("1", DefaultBranch), 3, "a failed commit must not change the branch")
+}
+
+func TestCreateCommitStartBranch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	s.WriteFile("1", DefaultBranch, "file.txt", []byte("content"))
+	_, _, err := c.Commits.CreateCommit("1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("feature"),
+		StartBranch:   gitlab.String(DefaultBranch),
+		CommitMessage: gitlab.String("Add other file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("other.txt")}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file.txt", "other.txt"}, s.Files("1", "feature"))
+	assert.Equal(t, []string{"file.txt"}, s.Files("1", DefaultBranch))
+
+	branch, _, err := c.Branches.GetBranch("1", "feature")
+	assert.NoError(t, err)
+	head, _ := s.Branch("1", "feature")
+	assert.Equal(t, head, branch.Commit.ID)
+
+	_, _, err = c.Commits.CreateCommit("1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("missing"),
+		CommitMessage: gitlab.String("Add file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt")}},
+	})
+	assert.Error(t, err, "a branch is only created with a start branch")
+}
+
+func TestGetFile(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	commit := s.WriteFile("1", DefaultBranch, "dir/file.txt", []byte("content"))
+	options := &gitlab.GetFileOptions{Ref: gitlab.String(DefaultBranch)}
+
+	f, _, err := c.RepositoryFiles.GetFile("1", "dir/file.txt", options)
+	assert.NoError(t, err)
+	assert.Equal(t, "Y29udGVudA==", f.Content)
+	assert.Equal(t, BlobID([]byte("content")), f.BlobID)
+	assert.Equal(t, commit, f.LastCommitID)
+
+	metadata, _, err := c.RepositoryFiles.GetFileMetaData("1", "dir/file.txt", &gitlab.GetFileMetaDataOptions{Ref: gitlab.String(DefaultBranch)})
+	assert.NoError(t, err)
+	assert.Equal(t, f.BlobID, metadata.BlobID)
+	assert.Equal(t, f.SHA256, metadata.SHA256)
+	assert.Equal(t, commit, metadata.LastCommitID)
+
+	_, resp, err := c.RepositoryFiles.GetFile("1", "dir/missing.txt", options)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, err.Error(), "404 File Not Found")
+}
+
+func TestBlobID(t *testing.T) {
+	// echo -n content | git hash-object --stdin
+	assert.Equal(t, "6b584e8ece562ebffc15d38808cd6b98fc3d97ea", BlobID([]byte("content")))
+}
+
+func TestListTree(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	for _, filePath := range []string{"a.txt", "dir/b.txt", "dir/c.txt", "dir/sub/d.txt"} {
+		s.WriteFile("1", DefaultBranch, filePath, []byte(filePath))
+	}
+
+	paths := func(nodes []*gitlab.TreeNode) []string {
+		var p []string
+		for _, node := range nodes {
+			p = append(p, node.Type+":"+node.Path)
+		}
+		return p
+	}
+
+	nodes, _, err := c.Repositories.ListTree("1", &gitlab.ListTreeOptions{Ref: gitlab.String(DefaultBranch)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob:a.txt", "tree:dir"}, paths(nodes))
+
+	nodes, _, err = c.Repositories.ListTree("1", &gitlab.ListTreeOptions{Ref: gitlab.String(DefaultBranch), Path: gitlab.String("dir")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob:dir/b.txt", "blob:dir/c.txt", "tree:dir/sub"}, paths(nodes))
+	assert.Equal(t, BlobID([]byte("dir/b.txt")), nodes[0].ID)
+
+	nodes, resp, err := c.Repositories.ListTree("1", &gitlab.ListTreeOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 2},
+		Ref:         gitlab.String(DefaultBranch),
+		Recursive:   gitlab.Bool(true),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob:a.txt", "tree:dir"}, paths(nodes))
+	assert.Equal(t, 2, resp.NextPage)
+	assert.Equal(t, 6, resp.TotalItems)
+
+	_, resp, err = c.Repositories.ListTree("1", &gitlab.ListTreeOptions{Ref: gitlab.String(DefaultBranch), Path: gitlab.String("missing")})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,284 @@
+// Package gitlabfake is an in-memory GitLab serving the parts of the API used by the provider, so the provider can be tested without a GitLab instance.
+package gitlabfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultBranch is the branch every project is created with
+const DefaultBranch = "main"
+
+// Server is a GitLab API served by httptest. Projects are created with an empty DefaultBranch when first used.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	projects map[string]*project
+
+	failures []*Failure
+
+	// requests are the served requests as "METHOD path", with the path unescaped
+	requests []string
+}
+
+// NewServer starts a fake GitLab, the API is served at URL + "/api/v4"
+func NewServer() *Server {
+	s := &Server{projects: map[string]*project{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Failure makes the requests matching Method and PathSuffix fail with Status instead of being served
+type Failure struct {
+	// Method matches the request method, every method matches if empty
+	Method string
+
+	// PathSuffix matches the end of the unescaped request path, every path matches if empty
+	PathSuffix string
+
+	Status int
+
+	// Message is returned as the GitLab error message
+	Message string
+
+	// Header is added to the response, e.g. Retry-After
+	Header http.Header
+
+	// Times is the number of requests failing, every matching request fails if zero
+	Times int
+
+	// Served handles the request before failing it, as if the response was lost after GitLab made the change
+	Served bool
+}
+
+// RefRace fails the next commits to the branch as if the branch had been updated at the same time
+func RefRace(branch string, times int) Failure {
+	return Failure{
+		Method:     http.MethodPost,
+		PathSuffix: "/repository/commits",
+		Status:     http.StatusBadRequest,
+		Message:    fmt.Sprintf("Could not update refs/heads/%s. Please refresh and try again..", branch),
+		Times:      times,
+	}
+}
+
+// RateLimited fails the next requests with 429 Too Many Requests, asking to retry after retryAfter seconds
+func RateLimited(retryAfter, times int) Failure {
+	return Failure{
+		Status:  http.StatusTooManyRequests,
+		Message: "Retry later",
+		Header:  http.Header{"Retry-After": []string{fmt.Sprint(retryAfter)}},
+		Times:   times,
+	}
+}
+
+// ServerError fails the next matching requests with 500 Internal Server Error
+func ServerError(method, pathSuffix string, times int) Failure {
+	return Failure{
+		Method:     method,
+		PathSuffix: pathSuffix,
+		Status:     http.StatusInternalServerError,
+		Message:    "500 Internal Server Error",
+		Times:      times,
+	}
+}
+
+// LostResponse serves the next matching requests but fails them with 502 Bad Gateway, as if a proxy lost the response
+func LostResponse(method, pathSuffix string, times int) Failure {
+	return Failure{
+		Method:     method,
+		PathSuffix: pathSuffix,
+		Status:     http.StatusBadGateway,
+		Message:    "502 Bad Gateway",
+		Times:      times,
+		Served:     true,
+	}
+}
+
+// Fail adds a failure, the failures are matched in the order they were added
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// Requests returns the number of served requests matching method and the end of the unescaped path, including failed requests
+func (s *Server) Requests(method, pathSuffix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, request := range s.requests {
+		if strings.HasPrefix(request, method+" ") && strings.HasSuffix(request, pathSuffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// apiError is returned by the handlers and written as a GitLab error
+type apiError struct {
+	status int
+
+	message string
+}
+
+func errorf(status int, format string, a ...interface{}) *apiError {
+	return &apiError{status: status, message: fmt.Sprintf(format, a...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "invalid path: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+"/"+strings.Join(segments, "/"))
+	f := s.failure(r.Method, "/"+strings.Join(segments, "/"))
+	if f != nil {
+		for key, values := range f.Header {
+			w.Header()[key] = values
+		}
+		if !f.Served {
+			writeError(w, errorf(f.Status, "%s", f.Message))
+			return
+		}
+	}
+
+	if len(segments) < 2 || segments[0] != "api" || segments[1] != "v4" {
+		writeError(w, errorf(http.StatusNotFound, "404 Not Found"))
+		return
+	}
+	segments = segments[2:]
+
+	var (
+		v    interface{}
+		aErr *apiError
+	)
+	switch {
+	case len(segments) == 0 || (len(segments) == 1 && segments[0] == ""):
+		// go-gitlab configures its rate limiter with the first request
+		v = map[string]string{}
+	case len(segments) == 1 && segments[0] == "version":
+		v = map[string]string{"version": "14.0.0", "revision": "gitlabfake"}
+	case len(segments) >= 3 && segments[0] == "projects":
+		v, aErr = s.serveProject(w, r, s.project(segments[1]), segments[2:])
+	default:
+		aErr = errorf(http.StatusNotFound, "404 Not Found")
+	}
+
+	if f != nil {
+		aErr = errorf(f.Status, "%s", f.Message)
+	}
+	if aErr != nil {
+		writeError(w, aErr)
+		return
+	}
+	if v == nil {
+		return
+	}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, v)
+}
+
+func (s *Server) serveProject(w http.ResponseWriter, r *http.Request, p *project, segments []string) (interface{}, *apiError) {
+	route := r.Method + " " + segments[0]
+	if len(segments) > 1 {
+		route += "/" + segments[1]
+	}
+
+	switch {
+	case route == "POST repository/commits" && len(segments) == 2:
+		return p.createCommitHandler(r)
+	case route == "GET repository/commits" && len(segments) == 3:
+		return p.getCommitHandler(segments[2])
+	case (route == "GET repository/files" || route == "HEAD repository/files") && len(segments) >= 3:
+		return p.getFileHandler(w, r, strings.Join(segments[2:], "/"))
+	case route == "GET repository/tree" && len(segments) == 2:
+		return p.listTreeHandler(w, r)
+	case route == "GET repository/branches" && len(segments) >= 3:
+		return p.getBranchHandler(strings.Join(segments[2:], "/"))
+	case route == "GET merge_requests" && len(segments) == 1:
+		return p.listMergeRequestsHandler(r)
+	case route == "POST merge_requests" && len(segments) == 1:
+		return p.createMergeRequestHandler(r)
+	case strings.HasPrefix(route, "GET merge_requests/") && len(segments) == 2:
+		return p.getMergeRequestHandler(segments[1])
+	case strings.HasPrefix(route, "PUT merge_requests/") && len(segments) == 2:
+		return p.updateMergeRequestHandler(r, segments[1])
+	case strings.HasPrefix(route, "PUT merge_requests/") && len(segments) == 3 && segments[2] == "merge":
+		return p.acceptMergeRequestHandler(r, segments[1])
+	}
+	return nil, errorf(http.StatusNotFound, "404 Not Found")
+}
+
+// failure returns the first failure matching the request and counts it, the caller must hold mu
+func (s *Server) failure(method, path string) *Failure {
+	for i, f := range s.failures {
+		if (f.Method != "" && f.Method != method) || !strings.HasSuffix(path, f.PathSuffix) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// project returns the project with the id, it is created if it does not exist. The caller must hold mu.
+func (s *Server) project(id string) *project {
+	p, ok := s.projects[id]
+	if !ok {
+		p = newProject(id, s.URL)
+		s.projects[id] = p
+	}
+	return p
+}
+
+// pathSegments splits the escaped path and unescapes the segments, so escaped slashes in project and file paths stay in one segment
+func pathSegments(escapedPath string) ([]string, error) {
+	segments := strings.Split(strings.TrimPrefix(escapedPath, "/"), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid body: %s", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.status, map[string]string{"message": err.message})
+}
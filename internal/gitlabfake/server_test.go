@@ -0,0 +1,100 @@
+package gitlabfake
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func newClient(t *testing.T, s *Server) *gitlab.Client {
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(s.URL), gitlab.WithoutRetries())
+	assert.NoError(t, err)
+	return c
+}
+
+func TestServerFailures(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	s.Fail(RefRace(DefaultBranch, 1))
+	s.Fail(ServerError(http.MethodGet, "/repository/branches/main", 0))
+
+	opts := &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(DefaultBranch),
+		CommitMessage: gitlab.String("Add file"),
+		Actions: []*gitlab.CommitActionOptions{
+			{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt"), Content: gitlab.String("content")},
+		},
+	}
+	_, resp, err := c.Commits.CreateCommit("1", opts)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, err.Error(), "Could not update refs/heads/main")
+
+	// the rate limit matches every request, it is added after the first request since go-gitlab requests the API root with it
+	s.Fail(RateLimited(3, 1))
+	_, resp, err = c.Commits.CreateCommit("1", opts)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Retry-After"))
+
+	_, _, err = c.Commits.CreateCommit("1", opts)
+	assert.NoError(t, err)
+
+	// a failure without times fails every matching request
+	for i := 0; i < 2; i++ {
+		_, resp, err = c.Branches.GetBranch("1", DefaultBranch)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	}
+
+	assert.Equal(t, 3, s.Requests(http.MethodPost, "/repository/commits"))
+	assert.Equal(t, 2, s.Requests(http.MethodGet, "/repository/branches/main"))
+}
+
+func TestServerLostResponse(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	s.Fail(LostResponse(http.MethodPost, "/repository/commits", 1))
+	opts := &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(DefaultBranch),
+		CommitMessage: gitlab.String("Add file"),
+		Actions: []*gitlab.CommitActionOptions{
+			{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt"), Content: gitlab.String("content")},
+		},
+	}
+	_, resp, err := c.Commits.CreateCommit("1", opts)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	// the commit was created although the request failed
+	content, ok := s.File("1", DefaultBranch, "file.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestServerPaths(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	version, _, err := c.Version.GetVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, "14.0.0", version.Version)
+
+	// the project path and the file path are escaped as one segment
+	s.WriteFile("group/project", DefaultBranch, "dir/.file.txt", []byte("content"))
+	f, _, err := c.RepositoryFiles.GetFile("group/project", "dir/.file.txt", &gitlab.GetFileOptions{Ref: gitlab.String(DefaultBranch)})
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/.file.txt", f.FilePath)
+	assert.Equal(t, 1, s.Requests(http.MethodGet, "/projects/group/project/repository/files/dir/.file.txt"))
+
+	_, resp, err := c.Projects.GetProject("1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
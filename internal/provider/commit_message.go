@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// coAuthorRegexp matches a co-author in the "Name <email>" format used by Co-authored-by trailers
+var coAuthorRegexp = regexp.MustCompile(`^[^<>\n]+ <[^<>\s]+@[^<>\s]+>$`)
+
+// commitAuthor is the author of a commit, requests with different authors are committed separately
+type commitAuthor struct {
+	name string
+
+	email string
+}
+
+// batchCommit is the commit created for the requests of a batch with the same location and author
+type batchCommit struct {
+	location commitLocation
+
+	author commitAuthor
+
+	requests []*commitRequest
+
+	// part and parts number the commits a batch exceeding the commitLimits is split into, both are zero for a batch that is not split
+	part, parts int
+
+	// parent is the SHA of the previous commit of a split batch, the commit is only created if it is still the head of the branch
+	parent string
+}
+
+// commitLimits limits the size of a single commit, zero is unlimited
+type commitLimits struct {
+	maxActions int
+
+	// maxBytes is compared to the size of the JSON encoded actions
+	maxBytes int
+}
+
+func (l commitLimits) exceeded(actions, bytes int) bool {
+	return (l.maxActions > 0 && actions > l.maxActions) || (l.maxBytes > 0 && bytes > l.maxBytes)
+}
+
+// split splits the batch into commits within the limits, in the order of the requests.
+// The actions of a request are never split, a request exceeding the limits on its own is committed alone.
+func (l commitLimits) split(batch *batchCommit) []*batchCommit {
+	var (
+		parts          []*batchCommit
+		current        *batchCommit
+		actions, bytes int
+	)
+	for _, request := range batch.requests {
+		requestBytes := actionsSize(request.actions)
+		if current == nil || (len(request.actions) > 0 && l.exceeded(actions+len(request.actions), bytes+requestBytes)) {
+			current = &batchCommit{location: batch.location, author: batch.author}
+			parts = append(parts, current)
+			actions, bytes = 0, 0
+		}
+		if l.exceeded(len(request.actions), requestBytes) {
+			log.Printf("[WARN] %s has %d actions of %d bytes exceeding the commit limits, it is committed alone", request.id, len(request.actions), requestBytes)
+		}
+		current.requests = append(current.requests, request)
+		actions += len(request.actions)
+		bytes += requestBytes
+	}
+
+	if len(parts) > 1 {
+		for i, part := range parts {
+			part.part, part.parts = i+1, len(parts)
+		}
+	}
+	return parts
+}
+
+// actionsSize returns the size of the actions in the body of the commit request
+func actionsSize(actions []*gitlab.CommitActionOptions) int {
+	var size int
+	for _, action := range actions {
+		// the options only contain strings, which always encode
+		b, _ := json.Marshal(action)
+		size += len(b)
+	}
+	return size
+}
+
+// groupRequests groups the requests into one commit per location and author, in the order they were first received.
+// Requests without actions only wait for the batch, they get the last commit for their location or a commit without actions if there is none.
+func groupRequests(requests []*commitRequest) []*batchCommit {
+	type key struct {
+		location commitLocation
+		author   commitAuthor
+	}
+
+	var (
+		commits []*batchCommit
+		byKey   = map[key]*batchCommit{}
+		waiting []*commitRequest
+	)
+	for _, request := range requests {
+		if len(request.actions) == 0 {
+			waiting = append(waiting, request)
+			continue
+		}
+		k := key{location: request.location, author: request.author}
+		commit, ok := byKey[k]
+		if !ok {
+			commit = &batchCommit{location: request.location, author: request.author}
+			byKey[k] = commit
+			commits = append(commits, commit)
+		}
+		commit.requests = append(commit.requests, request)
+	}
+
+	for _, request := range waiting {
+		var last *batchCommit
+		for _, commit := range commits {
+			if commit.location == request.location {
+				last = commit
+			}
+		}
+		if last == nil {
+			last = &batchCommit{location: request.location, author: request.author}
+			commits = append(commits, last)
+		}
+		last.requests = append(last.requests, request)
+	}
+
+	return commits
+}
+
+func (c *batchCommit) actions() []*gitlab.CommitActionOptions {
+	var actions []*gitlab.CommitActionOptions
+	for _, request := range c.requests {
+		actions = append(actions, request.actions...)
+	}
+	return actions
+}
+
+// message returns the commit message with the message fragments and co-authors of the requests.
+// The header of a split batch is suffixed with the number of the commit, e.g. "header (2/3)".
+func (c *batchCommit) message(header string) string {
+	if c.parts > 1 {
+		header = fmt.Sprintf("%s (%d/%d)", header, c.part, c.parts)
+	}
+	var messages, coAuthors []string
+	for _, request := range c.requests {
+		messages = append(messages, request.message)
+		coAuthors = append(coAuthors, request.coAuthor)
+	}
+	return commitMessage(header, messages, coAuthors)
+}
+
+// commitMessage combines the header with the message fragments as a bullet list followed by a Co-authored-by trailer per co-author.
+// Fragments and co-authors are sorted and deduplicated, so the message does not depend on the order the resources were applied in.
+func commitMessage(header string, messages, coAuthors []string) string {
+	var b strings.Builder
+	b.WriteString(header)
+
+	if messages := uniqueSorted(messages); len(messages) > 0 {
+		b.WriteString("\n\n")
+		for i, message := range messages {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("- " + message)
+		}
+	}
+
+	if coAuthors := uniqueSorted(coAuthors); len(coAuthors) > 0 {
+		b.WriteString("\n\n")
+		for i, coAuthor := range coAuthors {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("Co-authored-by: " + coAuthor)
+		}
+	}
+
+	return b.String()
+}
+
+// uniqueSorted returns the sorted non-empty values without duplicates
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		unique = append(unique, v)
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestCommitMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  []string
+		coAuthors []string
+		expected  string
+	}{
+		{
+			name:     "header only",
+			messages: []string{"", ""},
+			expected: "Update files",
+		},
+		{
+			name:     "sorted and deduplicated fragments",
+			messages: []string{"update b", "", "add a", "update b"},
+			expected: "Update files\n\n- add a\n- update b",
+		},
+		{
+			name:      "co-authors without fragments",
+			coAuthors: []string{"Jane <jane@example.com>"},
+			expected:  "Update files\n\nCo-authored-by: Jane <jane@example.com>",
+		},
+		{
+			name:      "fragments and co-authors",
+			messages:  []string{"update b", "add a"},
+			coAuthors: []string{"John <john@example.com>", "Jane <jane@example.com>", "John <john@example.com>"},
+			expected:  "Update files\n\n- add a\n- update b\n\nCo-authored-by: Jane <jane@example.com>\nCo-authored-by: John <john@example.com>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, commitMessage("Update files", tt.messages, tt.coAuthors))
+		})
+	}
+}
+
+func TestGroupRequests(t *testing.T) {
+	var (
+		main    = commitLocation{projectId: "1", branch: "main"}
+		develop = commitLocation{projectId: "1", branch: "develop"}
+		jane    = commitAuthor{name: "Jane", email: "jane@example.com"}
+		john    = commitAuthor{name: "John", email: "john@example.com"}
+		actions = []*gitlab.CommitActionOptions{{FilePath: gitlab.String("a")}}
+	)
+
+	requests := []*commitRequest{
+		{id: "a", location: main, author: jane, actions: actions},
+		{id: "b", location: main, author: john, actions: actions},
+		{id: "c", location: develop, author: jane, actions: actions},
+		{id: "d", location: main, author: jane, actions: actions},
+	}
+
+	commits := groupRequests(requests)
+	assert.Equal(t, []*batchCommit{
+		{location: main, author: jane, requests: []*commitRequest{requests[0], requests[3]}},
+		{location: main, author: john, requests: []*commitRequest{requests[1]}},
+		{location: develop, author: jane, requests: []*commitRequest{requests[2]}},
+	}, commits)
+}
+
+func TestBatchCommitMessage(t *testing.T) {
+	batch := &batchCommit{requests: []*commitRequest{
+		{message: "update b", coAuthor: "Jane <jane@example.com>", actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("b")}}},
+		{message: "add a", actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("a")}}},
+	}}
+
+	assert.Equal(t, "header\n\n- add a\n- update b\n\nCo-authored-by: Jane <jane@example.com>", batch.message("header"))
+	assert.Equal(t, []*gitlab.CommitActionOptions{{FilePath: gitlab.String("b")}, {FilePath: gitlab.String("a")}}, batch.actions())
+}
+
+func TestCoAuthorRegexp(t *testing.T) {
+	assert.True(t, coAuthorRegexp.MatchString("Jane Doe <jane@example.com>"))
+	assert.False(t, coAuthorRegexp.MatchString("jane@example.com"))
+	assert.False(t, coAuthorRegexp.MatchString("Jane <jane>"))
+	assert.False(t, coAuthorRegexp.MatchString("Jane\nCo-authored-by: John <john@example.com>"))
+}
+
+func TestGroupRequestsWithoutActions(t *testing.T) {
+	var (
+		main    = commitLocation{projectId: "1", branch: "main"}
+		develop = commitLocation{projectId: "1", branch: "develop"}
+		jane    = commitAuthor{name: "Jane", email: "jane@example.com"}
+		john    = commitAuthor{name: "John", email: "john@example.com"}
+		actions = []*gitlab.CommitActionOptions{{FilePath: gitlab.String("a")}}
+	)
+
+	requests := []*commitRequest{
+		{id: "waiting-main", location: main},
+		{id: "a", location: main, author: jane, actions: actions},
+		{id: "b", location: main, author: john, actions: actions},
+		{id: "waiting-develop", location: develop},
+	}
+
+	// a request without actions gets the last commit of its location
+	commits := groupRequests(requests)
+	assert.Equal(t, []*batchCommit{
+		{location: main, author: jane, requests: []*commitRequest{requests[1]}},
+		{location: main, author: john, requests: []*commitRequest{requests[2], requests[0]}},
+		{location: develop, requests: []*commitRequest{requests[3]}},
+	}, commits)
+	assert.Empty(t, commits[2].actions())
+}
+
+func TestCommitLimitsSplit(t *testing.T) {
+	var (
+		main    = commitLocation{projectId: "1", branch: "main"}
+		jane    = commitAuthor{name: "Jane", email: "jane@example.com"}
+		file    = &gitlab.CommitActionOptions{FilePath: gitlab.String("a"), Content: gitlab.String("0123456789")}
+		size    = actionsSize([]*gitlab.CommitActionOptions{file})
+		one     = []*gitlab.CommitActionOptions{file}
+		three   = []*gitlab.CommitActionOptions{file, file, file}
+		request = func(id string, actions []*gitlab.CommitActionOptions) *commitRequest {
+			return &commitRequest{id: id, location: main, author: jane, actions: actions}
+		}
+		ids = func(parts []*batchCommit) [][]string {
+			var ids [][]string
+			for _, part := range parts {
+				var partIds []string
+				for _, request := range part.requests {
+					partIds = append(partIds, request.id)
+				}
+				ids = append(ids, partIds)
+			}
+			return ids
+		}
+	)
+
+	tests := []struct {
+		name     string
+		limits   commitLimits
+		requests []*commitRequest
+		expected [][]string
+	}{
+		{
+			name:     "unlimited",
+			requests: []*commitRequest{request("a", three), request("b", three)},
+			expected: [][]string{{"a", "b"}},
+		},
+		{
+			name:     "max actions",
+			limits:   commitLimits{maxActions: 2},
+			requests: []*commitRequest{request("a", one), request("b", one), request("c", one)},
+			expected: [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:     "max bytes",
+			limits:   commitLimits{maxBytes: 2 * size},
+			requests: []*commitRequest{request("a", one), request("b", one), request("c", one)},
+			expected: [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:     "request exceeding the limits is committed alone",
+			limits:   commitLimits{maxActions: 2},
+			requests: []*commitRequest{request("a", one), request("b", three), request("c", one)},
+			expected: [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:     "request without actions stays in the last commit",
+			limits:   commitLimits{maxActions: 1},
+			requests: []*commitRequest{request("a", one), request("b", one), request("waiting", nil)},
+			expected: [][]string{{"a"}, {"b", "waiting"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := tt.limits.split(&batchCommit{location: main, author: jane, requests: tt.requests})
+			assert.Equal(t, tt.expected, ids(parts))
+			for _, part := range parts {
+				assert.Equal(t, main, part.location)
+				assert.Equal(t, jane, part.author)
+			}
+		})
+	}
+}
+
+func TestSplitBatchCommitMessage(t *testing.T) {
+	batch := &batchCommit{part: 2, parts: 3, requests: []*commitRequest{{message: "add a"}}}
+	assert.Equal(t, "header (2/3)\n\n- add a", batch.message("header"))
+}
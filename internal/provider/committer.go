@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	// backendGitlab commits with the GitLab API
+	backendGitlab = "gitlab"
+
+	// backendGit commits to a local git repository
+	backendGit = "git"
+)
+
+// Committer is the repository the resources are committed to and read from.
+// Errors of missing files, directories, commits and branches wrap os.ErrNotExist.
+// A rejected commit returns the GitLab error message, e.g. when the branch was updated at the same time, since the retries and conflicts are detected from it.
+type Committer interface {
+	// CreateCommit creates one commit with the actions of opts, like the GitLab commits API
+	CreateCommit(ctx context.Context, projectId string, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error)
+
+	GetCommit(ctx context.Context, projectId, sha string) (*gitlab.Commit, error)
+
+	// GetFile returns the file with the base64 encoded content at ref, which is a branch or commit
+	GetFile(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error)
+
+	// GetFileMetaData is like GetFile, but without the content
+	GetFileMetaData(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error)
+
+	// ListTree returns the files and directories in dir at ref, the repository root is listed if dir is empty
+	ListTree(ctx context.Context, projectId, dir, ref string, recursive bool) ([]*gitlab.TreeNode, error)
+
+	GetBranch(ctx context.Context, projectId, branch string) (*gitlab.Branch, error)
+}
+
+// gitlabCommitter is the Committer using the GitLab API
+type gitlabCommitter struct {
+	c *gitlab.Client
+}
+
+func newGitlabCommitter(c *gitlab.Client) *gitlabCommitter {
+	return &gitlabCommitter{c: c}
+}
+
+func (g *gitlabCommitter) CreateCommit(ctx context.Context, projectId string, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error) {
+	commit, resp, err := g.c.Commits.CreateCommit(projectId, opts, gitlab.WithContext(ctx))
+	if err != nil && resp != nil {
+		return nil, fmt.Errorf("status message %s: status code %d: %w", resp.Status, resp.StatusCode, err)
+	}
+	return commit, err
+}
+
+func (g *gitlabCommitter) GetCommit(ctx context.Context, projectId, sha string) (*gitlab.Commit, error) {
+	commit, resp, err := g.c.Commits.GetCommit(projectId, sha, gitlab.WithContext(ctx))
+	return commit, notFound(resp, err)
+}
+
+func (g *gitlabCommitter) GetFile(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error) {
+	file, resp, err := g.c.RepositoryFiles.GetFile(projectId, filePath, &gitlab.GetFileOptions{Ref: gitlab.String(ref)}, gitlab.WithContext(ctx))
+	return file, notFound(resp, err)
+}
+
+func (g *gitlabCommitter) GetFileMetaData(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error) {
+	file, resp, err := g.c.RepositoryFiles.GetFileMetaData(projectId, filePath, &gitlab.GetFileMetaDataOptions{Ref: gitlab.String(ref)}, gitlab.WithContext(ctx))
+	return file, notFound(resp, err)
+}
+
+func (g *gitlabCommitter) ListTree(ctx context.Context, projectId, dir, ref string, recursive bool) ([]*gitlab.TreeNode, error) {
+	var nodes []*gitlab.TreeNode
+	options := &gitlab.ListTreeOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Ref:         gitlab.String(ref),
+		Recursive:   gitlab.Bool(recursive),
+	}
+	if dir != "" {
+		options.Path = gitlab.String(dir)
+	}
+
+	for {
+		page, resp, err := g.c.Repositories.ListTree(projectId, options, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, notFound(resp, err)
+		}
+		nodes = append(nodes, page...)
+
+		if resp.NextPage == 0 {
+			return nodes, nil
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+func (g *gitlabCommitter) GetBranch(ctx context.Context, projectId, branch string) (*gitlab.Branch, error) {
+	b, resp, err := g.c.Branches.GetBranch(projectId, branch, gitlab.WithContext(ctx))
+	return b, notFound(resp, err)
+}
+
+// notFound wraps the error with os.ErrNotExist if GitLab responded with 404 Not Found
+func notFound(resp *gitlab.Response, err error) error {
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, err)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestGitlabCommitter(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	// the fake creates projects with an initial commit on the branch
+	server.WriteFile("1", "main", "README.md", []byte("readme"))
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	testCommitter(t, newGitlabCommitter(c))
+}
+
+// testCommitter checks the behavior the provider relies on, every Committer must pass it.
+// The repository must have a main branch with README.md and no other files.
+func testCommitter(t *testing.T, c Committer) {
+	ctx := context.Background()
+
+	create := func(actions ...*gitlab.CommitActionOptions) (*gitlab.Commit, error) {
+		return c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+			Branch:        gitlab.String("main"),
+			CommitMessage: gitlab.String("Update files\n\n- body"),
+			AuthorName:    gitlab.String("Terraform"),
+			AuthorEmail:   gitlab.String("terraform@example.com"),
+			Actions:       actions,
+		})
+	}
+
+	first, err := create(
+		&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("dir/a.txt"), Content: gitlab.String("a")},
+		&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("dir/sub/b.bin"), Content: gitlab.String("AAE="), Encoding: gitlab.String("base64")},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "Update files", first.Title)
+	assert.Equal(t, "Terraform", first.AuthorName)
+	assert.Equal(t, "terraform@example.com", first.AuthorEmail)
+
+	commit, err := c.GetCommit(ctx, "1", first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, commit.ID)
+	_, err = c.GetCommit(ctx, "1", "0123456789012345678901234567890123456789")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	branch, err := c.GetBranch(ctx, "1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, branch.Commit.ID)
+	_, err = c.GetBranch(ctx, "1", "missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	file, err := c.GetFile(ctx, "1", "dir/sub/b.bin", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/sub/b.bin", file.FilePath)
+	assert.Equal(t, "AAE=", file.Content)
+	assert.Equal(t, gitBlobID([]byte{0, 1}), file.BlobID)
+	assert.Equal(t, first.ID, file.LastCommitID)
+
+	metadata, err := c.GetFileMetaData(ctx, "1", "dir/a.txt", "main")
+	assert.NoError(t, err)
+	// echo -n a | sha256sum
+	assert.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", metadata.SHA256)
+	assert.Equal(t, first.ID, metadata.LastCommitID)
+	_, err = c.GetFile(ctx, "1", "dir/missing.txt", "main")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	nodes, err := c.ListTree(ctx, "1", "dir", "main", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob:dir/a.txt", "tree:dir/sub"}, treePaths(nodes))
+	nodes, err = c.ListTree(ctx, "1", "", "main", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"blob:README.md", "tree:dir", "blob:dir/a.txt", "tree:dir/sub", "blob:dir/sub/b.bin"}, treePaths(nodes))
+	_, err = c.ListTree(ctx, "1", "missing", "main", true)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the GitLab messages are used to detect existing files, conflicts and ref races
+	_, err = create(&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("dir/a.txt")})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "A file with this name already exists")
+	}
+	_, err = create(&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("dir/a.txt"), LastCommitID: gitlab.String("other")})
+	assert.True(t, isConflict(err), err)
+
+	second, err := create(
+		&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("dir/a.txt"), Content: gitlab.String("changed"), LastCommitID: gitlab.String(first.ID)},
+		&gitlab.CommitActionOptions{Action: gitlab.FileAction(gitlab.FileDelete), FilePath: gitlab.String("dir/sub/b.bin")},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID}, second.ParentIDs)
+	file, err = c.GetFile(ctx, "1", "dir/a.txt", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("changed")), file.Content)
+	_, err = c.GetFile(ctx, "1", "dir/sub/b.bin", "main")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// the files are read from commits as well
+	_, err = c.GetFile(ctx, "1", "dir/sub/b.bin", first.ID)
+	assert.NoError(t, err)
+
+	// the start SHA is only used to create a branch
+	_, err = c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		StartSHA:      gitlab.String(first.ID),
+		CommitMessage: gitlab.String("Behind"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("c.txt")}},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "A branch called 'main' already exists")
+	}
+	fromSHA, err := c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("from-sha"),
+		StartSHA:      gitlab.String(first.ID),
+		CommitMessage: gitlab.String("Add c.txt"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("c.txt")}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID}, fromSHA.ParentIDs)
+
+	feature, err := c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("feature"),
+		StartBranch:   gitlab.String("main"),
+		CommitMessage: gitlab.String("Add c.txt"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("c.txt"), Content: gitlab.String("c")}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.ID}, feature.ParentIDs)
+	_, err = c.GetFile(ctx, "1", "c.txt", "main")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = c.GetFile(ctx, "1", "c.txt", "feature")
+	assert.NoError(t, err)
+}
+
+func treePaths(nodes []*gitlab.TreeNode) []string {
+	var paths []string
+	for _, node := range nodes {
+		paths = append(paths, node.Type+":"+node.Path)
+	}
+	return paths
+}
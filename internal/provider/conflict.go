@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	// conflictStrategyFail sends the last known commit ID with updates and deletes so changes made outside of Terraform are not overwritten
+	conflictStrategyFail = "fail"
+
+	// conflictStrategyOverwrite overwrites the files regardless of changes made outside of Terraform
+	conflictStrategyOverwrite = "overwrite"
+)
+
+// conflictError is returned when a commit is rejected because files have been changed since they were last read
+type conflictError struct {
+	// changedBy maps the file path to the commit that changed the file, the commit is empty if the file has been deleted
+	changedBy map[string]string
+
+	err error
+}
+
+func (e *conflictError) Error() string {
+	var files []string
+	for filePath, commitID := range e.changedBy {
+		files = append(files, describeConflict(filePath, commitID))
+	}
+	sort.Strings(files)
+	return fmt.Sprintf("commit rejected since files have been changed after they were last read: %s", strings.Join(files, ", "))
+}
+
+func (e *conflictError) Unwrap() error {
+	return e.err
+}
+
+func describeConflict(filePath, commitID string) string {
+	if commitID == "" {
+		return fmt.Sprintf("'%s' was deleted", filePath)
+	}
+	return fmt.Sprintf("'%s' was changed by commit %s", filePath, commitID)
+}
+
+// isConflict checks if the commit was rejected by GitLab due to a last_commit_id mismatch
+func isConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "changed since you started editing it")
+}
+
+// findConflicts returns a conflictError with the files that no longer match the last commit ID sent with the actions
+func findConflicts(ctx context.Context, projectId, branch string, repository Committer, actions []*gitlab.CommitActionOptions, commitErr error) error {
+	changedBy := map[string]string{}
+	for _, action := range actions {
+		if action.LastCommitID == nil {
+			continue
+		}
+		file, err := repository.GetFile(ctx, projectId, *action.FilePath, branch)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				changedBy[*action.FilePath] = ""
+				continue
+			}
+			return fmt.Errorf("%v: unable to find the conflicting files: %w", commitErr, err)
+		}
+		if file.LastCommitID != *action.LastCommitID {
+			changedBy[*action.FilePath] = file.LastCommitID
+		}
+	}
+
+	if len(changedBy) == 0 {
+		return commitErr
+	}
+	return &conflictError{changedBy: changedBy, err: commitErr}
+}
+
+// diagFromCommitErr creates diagnostics for the resource with filePath, the diagnostic explains how to solve it if the file is in conflict
+func diagFromCommitErr(filePath string, err error) diag.Diagnostics {
+	var conflict *conflictError
+	if !errors.As(err, &conflict) {
+		return diag.FromErr(err)
+	}
+
+	commitID, changed := conflict.changedBy[filePath]
+	if !changed {
+		return diag.Diagnostics{{
+			Severity: diag.Error,
+			Summary:  "Commit rejected due to conflicting files",
+			Detail:   conflict.Error(),
+		}}
+	}
+
+	detail := fmt.Sprintf("The file was changed by commit %s after it was last read by Terraform.", commitID)
+	if commitID == "" {
+		detail = "The file was deleted after it was last read by Terraform."
+	}
+
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  fmt.Sprintf("File %s has been changed outside of Terraform", filePath),
+		Detail:   detail + fmt.Sprintf(" Run a new plan to include the change or set conflict_strategy to %q in the provider to overwrite it.", conflictStrategyOverwrite),
+	}}
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestFindConflicts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/files/unchanged.txt":
+			fmt.Fprint(w, `{"file_path":"unchanged.txt","last_commit_id":"aaa"}`)
+		case "/api/v4/projects/1/repository/files/changed.txt":
+			fmt.Fprint(w, `{"file_path":"changed.txt","last_commit_id":"ccc"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 File Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	actions := []*gitlab.CommitActionOptions{
+		{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("new.txt")},
+		{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("unchanged.txt"), LastCommitID: gitlab.String("aaa")},
+		{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("changed.txt"), LastCommitID: gitlab.String("bbb")},
+		{Action: gitlab.FileAction(gitlab.FileDelete), FilePath: gitlab.String("deleted.txt"), LastCommitID: gitlab.String("bbb")},
+	}
+	commitErr := errors.New("The file has changed since you started editing it: changed.txt")
+
+	err = findConflicts(context.Background(), "1", "main", newGitlabCommitter(c), actions, commitErr)
+
+	var conflict *conflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.ErrorIs(t, err, commitErr)
+	assert.Equal(t, map[string]string{"changed.txt": "ccc", "deleted.txt": ""}, conflict.changedBy)
+	assert.Equal(t, "commit rejected since files have been changed after they were last read: 'changed.txt' was changed by commit ccc, 'deleted.txt' was deleted", err.Error())
+}
+
+func TestDiagFromCommitErr(t *testing.T) {
+	err := &conflictError{changedBy: map[string]string{"changed.txt": "ccc"}}
+
+	diags := diagFromCommitErr("changed.txt", err)
+	assert.Len(t, diags, 1)
+	assert.Equal(t, "File changed.txt has been changed outside of Terraform", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, "commit ccc")
+
+	diags = diagFromCommitErr("other.txt", err)
+	assert.Len(t, diags, 1)
+	assert.Equal(t, "Commit rejected due to conflicting files", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, "changed.txt")
+
+	diags = diagFromCommitErr("other.txt", errors.New("some error"))
+	assert.Equal(t, "some error", diags[0].Summary)
+}
+
+func TestApplyActionLastCommitID(t *testing.T) {
+	tests := []struct {
+		strategy     string
+		action       gitlab.FileActionValue
+		expectedLast *string
+	}{
+		{strategy: conflictStrategyFail, action: gitlab.FileUpdate, expectedLast: gitlab.String("aaa")},
+		{strategy: conflictStrategyFail, action: gitlab.FileDelete, expectedLast: gitlab.String("aaa")},
+		{strategy: conflictStrategyFail, action: gitlab.FileCreate},
+		{strategy: conflictStrategyOverwrite, action: gitlab.FileUpdate},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.strategy, tt.action), func(t *testing.T) {
+			var (
+				actionCh = make(chan *commitRequest)
+				c        = &client{conflictStrategy: tt.strategy, actionCh: actionCh}
+			)
+
+			d := resourceGitlabCommit().Data(&terraform.InstanceState{
+				ID: "file.txt",
+				Attributes: map[string]string{
+					"file_path":      "file.txt",
+					"content":        "content",
+					"last_commit_id": "aaa",
+				},
+			})
+
+			go func() {
+				request := <-actionCh
+				assert.Equal(t, tt.expectedLast, request.actions[0].LastCommitID)
+				request.reply <- &responseSync{}
+			}()
+
+			_, err := applyAction(context.Background(), gitlab.FileAction(tt.action), c, d)
+			assert.NoError(t, err)
+		})
+	}
+}
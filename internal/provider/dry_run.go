@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// dryRun writes the commits to a file or the log instead of creating them
+type dryRun struct {
+	// output is the file the commits are written to as JSON lines, the commits are logged if it is empty
+	output string
+
+	mu sync.Mutex
+}
+
+// dryRunCommit is the record written for every commit that would have been created
+type dryRunCommit struct {
+	ProjectID string                      `json:"project_id"`
+	Commit    *gitlab.CreateCommitOptions `json:"commit"`
+	Stats     dryRunStats                 `json:"stats"`
+}
+
+// dryRunStats summarizes the actions of a commit. It is not a diff: the lines and bytes are the size of the new content
+// of the created and updated files, the current content is not compared.
+type dryRunStats struct {
+	Files        int            `json:"files"`
+	Actions      map[string]int `json:"actions"`
+	ContentLines int            `json:"content_lines"`
+	ContentBytes int            `json:"content_bytes"`
+}
+
+// newDryRun creates the output file, so it only contains the commits of this run
+func newDryRun(output string) (*dryRun, error) {
+	if output != "" {
+		if err := os.WriteFile(output, nil, 0600); err != nil {
+			return nil, fmt.Errorf("unable to create dry_run_output: %w", err)
+		}
+	}
+	return &dryRun{output: output}, nil
+}
+
+// write records the commit, no commit is returned since nothing has been created
+func (r *dryRun) write(projectId string, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error) {
+	stats, err := newDryRunStats(opts.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := json.Marshal(&dryRunCommit{ProjectID: projectId, Commit: opts, Stats: stats})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode dry run commit: %w", err)
+	}
+
+	if r.output == "" {
+		log.Printf("[INFO] dry run commit: %s", record)
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.output, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open dry_run_output: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(record, '\n')); err != nil {
+		return nil, fmt.Errorf("unable to write dry_run_output: %w", err)
+	}
+	logD(fmt.Sprintf("[PROVIDER] dry run: wrote commit with %d actions to %s", len(opts.Actions), r.output))
+	return nil, nil
+}
+
+func newDryRunStats(actions []*gitlab.CommitActionOptions) (dryRunStats, error) {
+	stats := dryRunStats{Actions: map[string]int{}}
+	files := map[string]bool{}
+
+	for _, action := range actions {
+		files[*action.FilePath] = true
+		stats.Actions[string(*action.Action)]++
+
+		if action.Content == nil {
+			continue
+		}
+		content := []byte(*action.Content)
+		if action.Encoding != nil && *action.Encoding == "base64" {
+			var err error
+			if content, err = base64.StdEncoding.DecodeString(*action.Content); err != nil {
+				return stats, fmt.Errorf("unable to decode content of %s: %w", *action.FilePath, err)
+			}
+		}
+		stats.ContentBytes += len(content)
+		stats.ContentLines += countLines(content)
+	}
+
+	stats.Files = len(files)
+	return stats, nil
+}
+
+// countLines counts the lines like git, where a last line without a newline is also a line
+func countLines(content []byte) int {
+	if len(content) == 0 {
+		return 0
+	}
+	lines := bytes.Count(content, []byte("\n"))
+	if content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestDryRunWrite(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "commits.json")
+	assert.NoError(t, os.WriteFile(output, []byte("from an earlier run\n"), 0600))
+
+	r, err := newDryRun(output)
+	assert.NoError(t, err)
+
+	opts := &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Update files"),
+		Actions: []*gitlab.CommitActionOptions{
+			{
+				Action:   gitlab.FileAction(gitlab.FileCreate),
+				FilePath: gitlab.String("a.txt"),
+				Content:  gitlab.String("one\ntwo\n"),
+			},
+			{
+				Action:   gitlab.FileAction(gitlab.FileUpdate),
+				FilePath: gitlab.String("b.bin"),
+				Content:  gitlab.String("AAEC"),
+				Encoding: gitlab.String("base64"),
+			},
+			{
+				Action:   gitlab.FileAction(gitlab.FileDelete),
+				FilePath: gitlab.String("c.txt"),
+			},
+		},
+	}
+
+	// sendCommitActions must not reach the GitLab API in a dry run
+	commit, err := sendCommitActions(context.Background(), retryPolicy{}, "1", nil, r, opts)
+	assert.NoError(t, err)
+	assert.Nil(t, commit)
+	commit, err = sendCommitActions(context.Background(), retryPolicy{}, "2", nil, r, opts)
+	assert.NoError(t, err)
+	assert.Nil(t, commit)
+
+	content, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2, "the output should only contain the commits of this run")
+
+	var record dryRunCommit
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "1", record.ProjectID)
+	assert.Equal(t, opts, record.Commit)
+	assert.Equal(t, dryRunStats{
+		Files:        3,
+		Actions:      map[string]int{"create": 1, "update": 1, "delete": 1},
+		ContentLines: 3,
+		ContentBytes: 11,
+	}, record.Stats)
+	assert.Contains(t, lines[0], `"content_lines":3,"content_bytes":11`, "the sizes should not be named like diff stats")
+}
+
+func TestCountLines(t *testing.T) {
+	assert.Equal(t, 0, countLines(nil))
+	assert.Equal(t, 1, countLines([]byte("one")))
+	assert.Equal(t, 1, countLines([]byte("one\n")))
+	assert.Equal(t, 2, countLines([]byte("one\ntwo")))
+}
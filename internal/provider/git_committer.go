@@ -0,0 +1,641 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/xanzy/go-gitlab"
+)
+
+// zeroID is the object ID git uses for a missing object, e.g. the old value of a ref being created
+const zeroID = "0000000000000000000000000000000000000000"
+
+// gitCommitter is the Committer writing to a local bare git repository with the git CLI.
+// The project ID is ignored since the repository is the only project. The GitLab error messages are returned for rejected commits.
+type gitCommitter struct {
+	// dir is the path of the bare repository
+	dir string
+
+	// remote is the URL the branches are pushed to after every commit, nothing is pushed when empty
+	remote string
+
+	// mu serializes the commits, the branches are updated with a compare and swap regardless
+	mu sync.Mutex
+
+	sumsMu sync.Mutex
+
+	// sums are the hex encoded SHA-256 of the blobs by blob ID, a blob never changes so it is only hashed once
+	sums map[string]string
+}
+
+func newGitCommitter(dir string) *gitCommitter {
+	return &gitCommitter{dir: dir, sums: map[string]string{}}
+}
+
+// openGitRepository opens the repository at path, which is either a bare repository or a working tree with a .git directory.
+// A missing repository is cloned from remoteURL as a bare repository, or initialized empty without remoteURL.
+// The branches are pushed to remoteURL after every commit when push is set.
+func openGitRepository(ctx context.Context, path, remoteURL string, push bool) (*gitCommitter, error) {
+	if push && remoteURL == "" {
+		return nil, errors.New("remote_url is required to push")
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		args := []string{"init", "--bare", path}
+		if remoteURL != "" {
+			args = []string{"clone", "--bare", "--", remoteURL, path}
+		}
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, gitError(args, err, string(out))
+		}
+		logD(fmt.Sprintf("[PROVIDER] created repository %s with git %s", path, args[0]))
+	} else if err != nil {
+		return nil, err
+	}
+
+	dir := path
+	if info, err := os.Stat(filepath.Join(path, ".git")); err == nil && info.IsDir() {
+		dir = filepath.Join(path, ".git")
+	}
+	g := newGitCommitter(dir)
+	if _, err := g.git(ctx, nil, nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", path, err)
+	}
+	if push {
+		g.remote = remoteURL
+	}
+	return g, nil
+}
+
+// newGitBackend opens the repository of the git block of the provider
+func newGitBackend(ctx context.Context, d *schema.ResourceData) (*gitCommitter, error) {
+	blocks := d.Get("git").([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		return nil, fmt.Errorf("the git block is required with the %s backend", backendGit)
+	}
+	if len(d.Get("merge_request").([]interface{})) > 0 {
+		return nil, fmt.Errorf("merge_request is not supported with the %s backend", backendGit)
+	}
+	block := blocks[0].(map[string]interface{})
+	return openGitRepository(ctx, block["path"].(string), block["remote_url"].(string), block["push"].(bool))
+}
+
+// treeEntry is a file in the tree of a commit
+type treeEntry struct {
+	mode string
+
+	blobID string
+}
+
+func (g *gitCommitter) CreateCommit(ctx context.Context, projectId string, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if opts.Branch == nil || *opts.Branch == "" {
+		return nil, errors.New("branch is missing")
+	}
+	branch := *opts.Branch
+
+	parent, head, err := g.parent(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*treeEntry{}
+	if parent != "" {
+		if files, err = g.files(ctx, parent); err != nil {
+			return nil, err
+		}
+	}
+	changed := map[string]bool{}
+	for _, action := range opts.Actions {
+		if err := g.apply(ctx, parent, files, changed, action); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, err := g.writeTree(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	var message string
+	if opts.CommitMessage != nil {
+		message = *opts.CommitMessage
+	}
+	args := []string{"commit-tree", tree, "-F", "-"}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	name, email := "Terraform", "terraform@localhost"
+	if opts.AuthorName != nil && *opts.AuthorName != "" {
+		name = *opts.AuthorName
+	}
+	if opts.AuthorEmail != nil && *opts.AuthorEmail != "" {
+		email = *opts.AuthorEmail
+	}
+	id, err := g.git(ctx, strings.NewReader(message), []string{
+		"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email,
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ref is only updated if it still has the head the commit is based on
+	if _, err := g.git(ctx, nil, nil, "update-ref", "refs/heads/"+branch, id, head); err != nil {
+		return nil, fmt.Errorf("Could not update refs/heads/%s. Please refresh and try again..: %w", branch, err)
+	}
+	logD(fmt.Sprintf("[PROVIDER] committed %s to %s in %s", id, branch, g.dir))
+
+	if g.remote != "" {
+		ref := "refs/heads/" + branch
+		if _, err := g.git(ctx, nil, nil, "push", "--porcelain", "--", g.remote, ref+":"+ref); err != nil {
+			// the branch is moved back, so the local branch does not have a commit reported as failed.
+			// The push might have failed since ctx is done, the reset is quick and must happen regardless.
+			resetArgs := []string{"update-ref", ref, head, id}
+			if head == zeroID {
+				resetArgs = []string{"update-ref", "-d", ref, id}
+			}
+			if _, resetErr := g.git(context.Background(), nil, nil, resetArgs...); resetErr != nil {
+				return nil, fmt.Errorf("commit %s was created in %s but could not be pushed to %s, and %s could not be reset: %s: %w", id, g.dir, g.remote, branch, resetErr, err)
+			}
+			return nil, fmt.Errorf("commit %s could not be pushed to %s, %s was reset: %w", id, g.remote, branch, err)
+		}
+		logD(fmt.Sprintf("[PROVIDER] pushed %s to %s", branch, g.remote))
+	}
+
+	return g.GetCommit(ctx, projectId, id)
+}
+
+// parent returns the commit the new commit is based on and the current head of the branch, the head is zeroID when the branch is created.
+// The parent is empty for the first commit of an empty repository.
+func (g *gitCommitter) parent(ctx context.Context, opts *gitlab.CreateCommitOptions) (parent, head string, err error) {
+	branch := *opts.Branch
+	head, err = g.resolve(ctx, "refs/heads/"+branch)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+	if !exists {
+		head = zeroID
+	}
+
+	switch {
+	case opts.StartSHA != nil:
+		// like GitLab, the start SHA is only used to create a branch
+		if exists {
+			return "", "", fmt.Errorf("A branch called '%s' already exists. Switch to that branch in order to make changes", branch)
+		}
+		if parent, err = g.resolve(ctx, *opts.StartSHA); err != nil {
+			return "", "", fmt.Errorf("Invalid start SHA %s: %w", *opts.StartSHA, err)
+		}
+		return parent, head, nil
+	case opts.StartBranch != nil && *opts.StartBranch != branch:
+		if exists {
+			return "", "", fmt.Errorf("A branch called '%s' already exists. Switch to that branch in order to make changes", branch)
+		}
+		if parent, err = g.resolve(ctx, "refs/heads/"+*opts.StartBranch); err != nil {
+			return "", "", fmt.Errorf("You can only create or edit files when you are on a branch: %w", err)
+		}
+		return parent, head, nil
+	case exists:
+		return head, head, nil
+	}
+
+	// like GitLab, the first commit of an empty repository creates the branch
+	refs, err := g.git(ctx, nil, nil, "for-each-ref", "--count=1", "refs/heads/")
+	if err != nil {
+		return "", "", err
+	}
+	if refs != "" {
+		return "", "", fmt.Errorf("You can only create or edit files when you are on a branch: %w: %s", os.ErrNotExist, branch)
+	}
+	return "", head, nil
+}
+
+// apply changes the files with the action, the blobs of created and updated files are written to the repository
+func (g *gitCommitter) apply(ctx context.Context, parent string, files map[string]*treeEntry, changed map[string]bool, action *gitlab.CommitActionOptions) error {
+	if action.Action == nil || action.FilePath == nil {
+		return errors.New("action and file_path are required")
+	}
+	filePath := *action.FilePath
+	existing, exists := files[filePath]
+
+	checkLastCommit := func(filePath string) error {
+		if action.LastCommitID == nil || changed[filePath] {
+			return nil
+		}
+		lastCommitID, err := g.lastCommit(ctx, parent, filePath)
+		if err != nil {
+			return err
+		}
+		if lastCommitID != *action.LastCommitID {
+			return errors.New("You are attempting to update a file that has changed since you started editing it.")
+		}
+		return nil
+	}
+	writeBlob := func() (string, error) {
+		content := []byte{}
+		if action.Content != nil {
+			content = []byte(*action.Content)
+		}
+		if action.Encoding != nil && *action.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(string(content))
+			if err != nil {
+				return "", fmt.Errorf("invalid base64 content of %s: %w", filePath, err)
+			}
+			content = decoded
+		}
+		return g.git(ctx, bytes.NewReader(content), nil, "hash-object", "-w", "--stdin")
+	}
+
+	switch *action.Action {
+	case gitlab.FileCreate:
+		if exists {
+			return errors.New("A file with this name already exists")
+		}
+		blobID, err := writeBlob()
+		if err != nil {
+			return err
+		}
+		files[filePath] = &treeEntry{mode: "100644", blobID: blobID}
+	case gitlab.FileUpdate:
+		if !exists {
+			return errors.New("A file with this name doesn't exist")
+		}
+		if err := checkLastCommit(filePath); err != nil {
+			return err
+		}
+		blobID, err := writeBlob()
+		if err != nil {
+			return err
+		}
+		files[filePath] = &treeEntry{mode: existing.mode, blobID: blobID}
+	case gitlab.FileDelete:
+		if !exists {
+			return errors.New("A file with this name doesn't exist")
+		}
+		if err := checkLastCommit(filePath); err != nil {
+			return err
+		}
+		delete(files, filePath)
+	case gitlab.FileMove:
+		if action.PreviousPath == nil {
+			return fmt.Errorf("previous_path is required to move %s", filePath)
+		}
+		previous, ok := files[*action.PreviousPath]
+		if !ok {
+			return errors.New("A file with this name doesn't exist")
+		}
+		if exists {
+			return errors.New("A file with this name already exists")
+		}
+		if err := checkLastCommit(*action.PreviousPath); err != nil {
+			return err
+		}
+		moved := &treeEntry{mode: previous.mode, blobID: previous.blobID}
+		if action.Content != nil {
+			blobID, err := writeBlob()
+			if err != nil {
+				return err
+			}
+			moved.blobID = blobID
+		}
+		delete(files, *action.PreviousPath)
+		files[filePath] = moved
+	case gitlab.FileChmod:
+		if !exists {
+			return errors.New("A file with this name doesn't exist")
+		}
+		mode := "100644"
+		if action.ExecuteFilemode != nil && *action.ExecuteFilemode {
+			mode = "100755"
+		}
+		files[filePath] = &treeEntry{mode: mode, blobID: existing.blobID}
+	default:
+		return fmt.Errorf("unknown action %s", *action.Action)
+	}
+	changed[filePath] = true
+	return nil
+}
+
+// files returns the files in the tree of the commit by path
+func (g *gitCommitter) files(ctx context.Context, commit string) (map[string]*treeEntry, error) {
+	out, err := g.git(ctx, nil, nil, "ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*treeEntry{}
+	for _, line := range strings.Split(out, "\x00") {
+		mode, objectType, id, filePath, ok := parseTreeLine(line)
+		if !ok || objectType != "blob" {
+			continue
+		}
+		files[filePath] = &treeEntry{mode: mode, blobID: id}
+	}
+	return files, nil
+}
+
+// writeTree writes the tree with the files using a temporary index, so the repository needs no working tree
+func (g *gitCommitter) writeTree(ctx context.Context, files map[string]*treeEntry) (string, error) {
+	index, err := os.CreateTemp("", "gitlabcommit-index-*")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	// git fails on an empty file as index, it creates the index if it does not exist
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	var info strings.Builder
+	for filePath, entry := range files {
+		fmt.Fprintf(&info, "%s %s\t%s\x00", entry.mode, entry.blobID, filePath)
+	}
+	if _, err := g.git(ctx, strings.NewReader(info.String()), env, "update-index", "--add", "-z", "--index-info"); err != nil {
+		return "", err
+	}
+	return g.git(ctx, nil, env, "write-tree")
+}
+
+func (g *gitCommitter) GetCommit(ctx context.Context, projectId, sha string) (*gitlab.Commit, error) {
+	id, err := g.resolve(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.git(ctx, nil, nil, "show", "-s", "--format=%H%x00%P%x00%an%x00%ae%x00%at%x00%cn%x00%ce%x00%ct%x00%B", id)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(out, "\x00", 9)
+	if len(fields) != 9 {
+		return nil, fmt.Errorf("unexpected commit format of %s", id)
+	}
+	authored, committed := unixTime(fields[4]), unixTime(fields[7])
+	parents := strings.Fields(fields[1])
+	if parents == nil {
+		parents = []string{}
+	}
+	return &gitlab.Commit{
+		ID:             fields[0],
+		ShortID:        fields[0][:8],
+		Title:          strings.SplitN(fields[8], "\n", 2)[0],
+		Message:        fields[8],
+		AuthorName:     fields[2],
+		AuthorEmail:    fields[3],
+		AuthoredDate:   authored,
+		CommitterName:  fields[5],
+		CommitterEmail: fields[6],
+		CommittedDate:  committed,
+		CreatedAt:      committed,
+		ParentIDs:      parents,
+	}, nil
+}
+
+func (g *gitCommitter) GetFile(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error) {
+	file, err := g.fileMetaData(ctx, filePath, ref)
+	if err != nil {
+		return nil, err
+	}
+	content, err := g.gitBytes(ctx, "cat-file", "blob", file.BlobID)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	file.Size = len(content)
+	file.SHA256 = hex.EncodeToString(sum[:])
+	file.Content = base64.StdEncoding.EncodeToString(content)
+	g.sumsMu.Lock()
+	g.sums[file.BlobID] = file.SHA256
+	g.sumsMu.Unlock()
+	return file, nil
+}
+
+func (g *gitCommitter) GetFileMetaData(ctx context.Context, projectId, filePath, ref string) (*gitlab.File, error) {
+	file, err := g.fileMetaData(ctx, filePath, ref)
+	if err != nil {
+		return nil, err
+	}
+	size, err := g.git(ctx, nil, nil, "cat-file", "-s", file.BlobID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Size, err = strconv.Atoi(size); err != nil {
+		return nil, fmt.Errorf("invalid size of blob %s: %w", file.BlobID, err)
+	}
+	if file.SHA256, err = g.blobSHA256(ctx, file.BlobID); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// fileMetaData returns the file without the content, size and SHA-256, which all require reading the blob
+func (g *gitCommitter) fileMetaData(ctx context.Context, filePath, ref string) (*gitlab.File, error) {
+	commit, err := g.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.git(ctx, nil, nil, "ls-tree", "-z", commit, "--", filePath)
+	if err != nil {
+		return nil, err
+	}
+	_, objectType, blobID, _, ok := parseTreeLine(strings.TrimSuffix(out, "\x00"))
+	if !ok || objectType != "blob" {
+		return nil, fmt.Errorf("%w: 404 File Not Found: %s", os.ErrNotExist, filePath)
+	}
+
+	lastCommitID, err := g.lastCommit(ctx, commit, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitlab.File{
+		FileName:     path.Base(filePath),
+		FilePath:     filePath,
+		Encoding:     "base64",
+		Ref:          ref,
+		BlobID:       blobID,
+		CommitID:     commit,
+		LastCommitID: lastCommitID,
+	}, nil
+}
+
+// blobSHA256 returns the hex encoded SHA-256 of the blob, the blob is streamed into the hash the first time instead of being loaded
+func (g *gitCommitter) blobSHA256(ctx context.Context, blobID string) (string, error) {
+	g.sumsMu.Lock()
+	sum, ok := g.sums[blobID]
+	g.sumsMu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	args := []string{"cat-file", "blob", blobID}
+	cmd := g.command(ctx, nil, args...)
+	h := sha256.New()
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = h, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", gitError(args, err, stderr.String())
+	}
+
+	sum = hex.EncodeToString(h.Sum(nil))
+	g.sumsMu.Lock()
+	g.sums[blobID] = sum
+	g.sumsMu.Unlock()
+	return sum, nil
+}
+
+func (g *gitCommitter) ListTree(ctx context.Context, projectId, dir, ref string, recursive bool) ([]*gitlab.TreeNode, error) {
+	commit, err := g.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"ls-tree", "-z"}
+	if recursive {
+		args = append(args, "-r", "-t")
+	}
+	args = append(args, commit)
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		args = append(args, "--", dir+"/")
+	}
+	out, err := g.git(ctx, nil, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*gitlab.TreeNode
+	for _, line := range strings.Split(out, "\x00") {
+		mode, objectType, id, nodePath, ok := parseTreeLine(line)
+		if !ok {
+			continue
+		}
+		nodes = append(nodes, &gitlab.TreeNode{ID: id, Name: path.Base(nodePath), Type: objectType, Path: nodePath, Mode: mode})
+	}
+	if dir != "" && len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: 404 Tree Not Found: %s", os.ErrNotExist, dir)
+	}
+	return nodes, nil
+}
+
+func (g *gitCommitter) GetBranch(ctx context.Context, projectId, branch string) (*gitlab.Branch, error) {
+	id, err := g.resolve(ctx, "refs/heads/"+branch)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.GetCommit(ctx, projectId, id)
+	if err != nil {
+		return nil, err
+	}
+	return &gitlab.Branch{Name: branch, Commit: commit}, nil
+}
+
+// resolve returns the commit ID of a branch, ref or commit
+func (g *gitCommitter) resolve(ctx context.Context, ref string) (string, error) {
+	id, err := g.git(ctx, nil, nil, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", fmt.Errorf("%w: %s not found", os.ErrNotExist, ref)
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+// lastCommit returns the last commit changing the file before or at commit
+func (g *gitCommitter) lastCommit(ctx context.Context, commit, filePath string) (string, error) {
+	if commit == "" {
+		return "", nil
+	}
+	return g.git(ctx, nil, nil, "log", "-1", "--format=%H", commit, "--", filePath)
+}
+
+// git runs the git command in the repository and returns the output without the trailing newline
+func (g *gitCommitter) git(ctx context.Context, stdin io.Reader, env []string, args ...string) (string, error) {
+	cmd := g.command(ctx, env, args...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", gitError(args, err, stderr.String())
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+// gitBytes runs the git command in the repository and returns the unmodified output, used for file content
+func (g *gitCommitter) gitBytes(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := g.command(ctx, nil, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, gitError(args, err, stderr.String())
+	}
+	return out, nil
+}
+
+func (g *gitCommitter) command(ctx context.Context, env []string, args ...string) *exec.Cmd {
+	dir, err := filepath.Abs(g.dir)
+	if err != nil {
+		dir = g.dir
+	}
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", dir}, args...)...)
+	// git must fail instead of waiting for credentials
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	return cmd
+}
+
+// gitError wraps the error of a git command with its stderr, the exec.ExitError is kept for checking the exit code
+func gitError(args []string, err error, stderr string) error {
+	return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr))
+}
+
+// parseTreeLine parses a line of git ls-tree -z: "<mode> <type> <id>\t<path>"
+func parseTreeLine(line string) (mode, objectType, id, filePath string, ok bool) {
+	meta, filePath, found := cut(line, "\t")
+	if !found {
+		return "", "", "", "", false
+	}
+	fields := strings.Fields(meta)
+	if len(fields) != 3 {
+		return "", "", "", "", false
+	}
+	return fields[0], fields[1], fields[2], filePath, true
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+func unixTime(s string) *time.Time {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// newBareRepository initializes a bare repository in a temporary directory, the test is skipped without git
+func newBareRepository(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "--bare", dir).CombinedOutput(); err != nil {
+		t.Fatalf("unable to initialize repository: %s: %s", err, out)
+	}
+	return dir
+}
+
+func TestGitCommitter(t *testing.T) {
+	c := newGitCommitter(newBareRepository(t))
+
+	// the first commit to an empty repository creates the branch
+	_, err := c.CreateCommit(context.Background(), "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Initial commit"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("README.md"), Content: gitlab.String("readme")}},
+	})
+	assert.NoError(t, err)
+
+	testCommitter(t, c)
+}
+
+func TestGitCommitterActions(t *testing.T) {
+	c := newGitCommitter(newBareRepository(t))
+	ctx := context.Background()
+
+	_, err := c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Add script"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("run.sh"), Content: gitlab.String("echo")}},
+	})
+	assert.NoError(t, err)
+
+	_, err = c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Move script"),
+		Actions: []*gitlab.CommitActionOptions{
+			{Action: gitlab.FileAction(gitlab.FileMove), FilePath: gitlab.String("bin/run.sh"), PreviousPath: gitlab.String("run.sh")},
+			{Action: gitlab.FileAction(gitlab.FileChmod), FilePath: gitlab.String("bin/run.sh"), ExecuteFilemode: gitlab.Bool(true)},
+		},
+	})
+	assert.NoError(t, err)
+
+	nodes, err := c.ListTree(ctx, "1", "", "main", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tree:bin", "blob:bin/run.sh"}, treePaths(nodes))
+	assert.Equal(t, "100755", nodes[1].Mode)
+	assert.Equal(t, gitBlobID([]byte("echo")), nodes[1].ID)
+
+	_, err = c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("other"),
+		CommitMessage: gitlab.String("Add file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt")}},
+	})
+	assert.Error(t, err, "a branch is only created from a start branch once the repository has commits")
+}
+
+func TestOpenGitRepository(t *testing.T) {
+	ctx := context.Background()
+	remote := newBareRepository(t)
+
+	_, err := openGitRepository(ctx, filepath.Join(t.TempDir(), "repo"), "", true)
+	assert.Error(t, err, "pushing requires a remote")
+	_, err = openGitRepository(ctx, t.TempDir(), "", false)
+	assert.Error(t, err, "an existing directory must be a repository")
+
+	// a missing repository is initialized empty without a remote
+	empty, err := openGitRepository(ctx, filepath.Join(t.TempDir(), "empty"), "", false)
+	assert.NoError(t, err)
+	_, err = empty.GetBranch(ctx, "1", "main")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a missing repository is cloned from the remote and pushed to after every commit
+	path := filepath.Join(t.TempDir(), "clone")
+	c, err := openGitRepository(ctx, path, "file://"+remote, true)
+	assert.NoError(t, err)
+	commit, err := c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Add file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("file.txt"), Content: gitlab.String("a")}},
+	})
+	assert.NoError(t, err)
+	branch, err := newGitCommitter(remote).GetBranch(ctx, "1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, commit.ID, branch.Commit.ID)
+
+	// an existing repository is opened, also when it is a working tree
+	clone, err := openGitRepository(ctx, path, "", false)
+	assert.NoError(t, err)
+	_, err = clone.GetFile(ctx, "1", "file.txt", "main")
+	assert.NoError(t, err)
+
+	workingTree := filepath.Join(t.TempDir(), "work")
+	if out, err := exec.Command("git", "clone", "file://"+remote, workingTree).CombinedOutput(); err != nil {
+		t.Fatalf("unable to clone repository: %s: %s", err, out)
+	}
+	work, err := openGitRepository(ctx, workingTree, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(workingTree, ".git"), work.dir)
+
+	// the local branch is reset when the push is rejected
+	assert.NoError(t, os.RemoveAll(remote))
+	_, err = c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("main"),
+		CommitMessage: gitlab.String("Update file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("file.txt"), Content: gitlab.String("b")}},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "could not be pushed")
+	}
+	branch, err = c.GetBranch(ctx, "1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, commit.ID, branch.Commit.ID)
+
+	// a branch created by the rejected commit is removed
+	_, err = c.CreateCommit(ctx, "1", &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String("feature"),
+		StartBranch:   gitlab.String("main"),
+		CommitMessage: gitlab.String("Update file"),
+		Actions:       []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileUpdate), FilePath: gitlab.String("file.txt"), Content: gitlab.String("b")}},
+	})
+	assert.Error(t, err)
+	_, err = c.GetBranch(ctx, "1", "feature")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestGitBackend(t *testing.T) {
+	var (
+		remote        = newBareRepository(t)
+		numberOfFiles = 3
+		states        = make([]*terraform.InstanceState, numberOfFiles)
+	)
+
+	p := New()
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"backend":          backendGit,
+		"commit_message":   "Update files",
+		"author_name":      "Terraform",
+		"author_email":     "terraform@example.com",
+		"expected_changes": numberOfFiles,
+		"git": []interface{}{map[string]interface{}{
+			"path":       filepath.Join(t.TempDir(), "repo"),
+			"remote_url": "file://" + remote,
+			"push":       true,
+		}},
+	}))
+	if diags.HasError() {
+		t.Fatalf("unable to configure provider: %+v", diags)
+	}
+	c := p.Meta().(*client)
+	assert.Nil(t, c.gitlab)
+
+	wg := sync.WaitGroup{}
+	wg.Add(numberOfFiles)
+	for i := 0; i < numberOfFiles; i++ {
+		go func(i int) {
+			defer wg.Done()
+			state, err := applyResource(p, "gitlabcommit_file", nil, map[string]interface{}{"file_path": fmt.Sprintf("file-%d.txt", i), "content": fmt.Sprintf("content %d", i)})
+			assert.NoError(t, err)
+			states[i] = state
+		}(i)
+	}
+	wg.Wait()
+
+	// the files are committed in one commit and pushed
+	pushed := newGitCommitter(remote)
+	branch, err := pushed.GetBranch(context.Background(), "", "main")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, branch.Commit.ParentIDs)
+	assert.Equal(t, "Terraform", branch.Commit.AuthorName)
+	assert.Equal(t, "terraform@example.com", branch.Commit.AuthorEmail)
+	assert.Equal(t, "Update files", branch.Commit.Title)
+	for i := 0; i < numberOfFiles; i++ {
+		assert.Equal(t, branch.Commit.ID, states[i].Attributes["commit_id"])
+	}
+
+	// the files are read from the local repository
+	state, diags := p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), states[0], c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	assert.Equal(t, "content 0", state.Attributes["content"])
+
+	// a file committed by a merge request, e.g. before the backend was changed, is read from its branch
+	state.Attributes["merge_request_iid"] = "7"
+	state, diags = p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), state, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	state, err = applyResource(p, "gitlabcommit_file", state, map[string]interface{}{"file_path": "file-0.txt", "content": "updated"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "0", state.Attributes["merge_request_iid"])
+	}
+
+	_, err = applyResource(p, "gitlabcommit_merge_request", nil, map[string]interface{}{"source_branch": "feature"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "requires the gitlab backend")
+	}
+
+	// a merge request in the state, e.g. from before the backend was changed, is neither updated nor closed
+	mergeRequest := &terraform.InstanceState{
+		ID:         "7",
+		Attributes: map[string]string{"id": "7", "iid": "7", "source_branch": "feature", "title": "Update files", "state": "opened"},
+	}
+	_, err = applyResource(p, "gitlabcommit_merge_request", mergeRequest, map[string]interface{}{"source_branch": "feature", "title": "Update more files"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "requires the gitlab backend")
+	}
+	_, err = applyResource(p, "gitlabcommit_merge_request", mergeRequest, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "requires the gitlab backend")
+	}
+}
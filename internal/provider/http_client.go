@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// newHTTPClient creates the http.Client used by the gitlab.Client with the TLS settings given to the provider
+func newHTTPClient(d *schema.ResourceData) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(d)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	// every attempt of a retried request is rate limited
+	limited := newRateLimitTransport(transport, d.Get("max_requests_per_second").(float64), d.Get("max_concurrent_requests").(int))
+	return &http.Client{Transport: &retryTransport{next: limited, policy: newRetryPolicy(d)}}, nil
+}
+
+func newTLSConfig(d *schema.ResourceData) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: d.Get("insecure_skip_verify").(bool),
+	}
+
+	caCert := []byte(d.Get("ca_cert").(string))
+	if caFile := d.Get("ca_cert_file").(string); caFile != "" {
+		var err error
+		caCert, err = os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificate file: %w", err)
+		}
+	}
+	if len(caCert) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logD("unable to load system cert pool, using an empty pool: " + err.Error())
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("unable to parse CA certificate: no PEM encoded certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	clientCert, clientKey := d.Get("client_cert").(string), d.Get("client_key").(string)
+	if clientCert != "" && clientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client certificate and key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	server := httptest.NewTLSServer(versionHandler())
+	defer server.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte(caCert), 0600))
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:    "unknown certificate authority",
+			config:  map[string]interface{}{},
+			wantErr: true,
+		},
+		{
+			name:   "ca_cert",
+			config: map[string]interface{}{"ca_cert": caCert},
+		},
+		{
+			name:   "ca_cert_file",
+			config: map[string]interface{}{"ca_cert_file": caFile},
+		},
+		{
+			name:   "insecure_skip_verify",
+			config: map[string]interface{}{"insecure_skip_verify": true},
+		},
+		{
+			name:    "invalid ca_cert",
+			config:  map[string]interface{}{"ca_cert": "not a certificate"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["gitlab_api_token"] = "token"
+			tt.config["project_id"] = "1"
+			tt.config["base_url"] = server.URL
+
+			_, diags := configure(context.Background(), schema.TestResourceDataRaw(t, New().Schema, tt.config))
+			assert.Equal(t, tt.wantErr, diags.HasError(), fmt.Sprintf("%+v", diags))
+		})
+	}
+}
+
+func TestConfigureMutualTLS(t *testing.T) {
+	clientCert, clientKey := mustGenerateCertificate(t)
+
+	clientCAs := x509.NewCertPool()
+	assert.True(t, clientCAs.AppendCertsFromPEM(clientCert))
+
+	server := httptest.NewUnstartedServer(versionHandler())
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	config := map[string]interface{}{
+		"gitlab_api_token":     "token",
+		"project_id":           "1",
+		"base_url":             server.URL,
+		"insecure_skip_verify": true,
+	}
+
+	_, diags := configure(context.Background(), schema.TestResourceDataRaw(t, New().Schema, config))
+	assert.True(t, diags.HasError(), "expected missing client certificate to fail")
+
+	config["client_cert"] = string(clientCert)
+	config["client_key"] = string(clientKey)
+
+	_, diags = configure(context.Background(), schema.TestResourceDataRaw(t, New().Schema, config))
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+}
+
+func versionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"version":"14.3.0","revision":"abc"}`)
+	})
+}
+
+// mustGenerateCertificate creates a self-signed client certificate and returns the PEM encoded certificate and key
+func mustGenerateCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "terraform-provider-gitlabcommit"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
@@ -0,0 +1,282 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/xanzy/go-gitlab"
+)
+
+// mergeRequestMode commits the batches to a source branch and opens a merge request into the branch of the resources.
+// It is only used by the actionSyncronizer goroutine and is therefore not safe for concurrent use.
+type mergeRequestMode struct {
+	c *gitlab.Client
+
+	// repository has the source branches
+	repository Committer
+
+	// retry is the policy for enabling merge when pipeline succeeds until the pipeline exists
+	retry retryPolicy
+
+	title string
+
+	description string
+
+	labels []string
+
+	assigneeIDs []int
+
+	reviewerIDs []int
+
+	removeSourceBranch bool
+
+	mergeWhenPipelineSucceeds bool
+
+	// sourceBranch is the configured source branch, a branch per target branch is generated when empty
+	sourceBranch string
+
+	// startBranch is the branch the source branch is created from, defaults to the target branch when empty
+	startBranch string
+
+	// created is used to generate the source branch names of new merge requests
+	created time.Time
+
+	// sourceBranches are the source branches used in this run by location
+	sourceBranches map[commitLocation]string
+
+	// mergeRequests are the merge requests used in this run by location
+	mergeRequests map[commitLocation]*gitlab.MergeRequest
+}
+
+// newMergeRequestMode reads the merge_request block of the provider, it returns nil if the block is not set
+func newMergeRequestMode(d *schema.ResourceData, c *gitlab.Client, repository Committer, policy retryPolicy) *mergeRequestMode {
+	blocks := d.Get("merge_request").([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		return nil
+	}
+	block := blocks[0].(map[string]interface{})
+
+	title := block["title"].(string)
+	if title == "" {
+		title = d.Get("commit_message").(string)
+	}
+
+	return &mergeRequestMode{
+		c:                         c,
+		repository:                repository,
+		retry:                     policy,
+		title:                     title,
+		description:               block["description"].(string),
+		labels:                    toStrings(block["labels"]),
+		assigneeIDs:               toInts(block["assignee_ids"]),
+		reviewerIDs:               toInts(block["reviewer_ids"]),
+		removeSourceBranch:        block["remove_source_branch"].(bool),
+		mergeWhenPipelineSucceeds: block["merge_when_pipeline_succeeds"].(bool),
+		sourceBranch:              block["source_branch"].(string),
+		startBranch:               d.Get("start_branch").(string),
+		created:                   time.Now(),
+		sourceBranches:            map[commitLocation]string{},
+		mergeRequests:             map[commitLocation]*gitlab.MergeRequest{},
+	}
+}
+
+// generatedBranchPrefix is the start of the source branches generated for the target branch
+func generatedBranchPrefix(targetBranch string) string {
+	return "terraform-provider-gitlabcommit/" + targetBranch + "-"
+}
+
+// sourceBranchFor returns the branch the commits for the location are sent to.
+// A generated source branch of an open merge request is reused, since the files of the location are read from it until the merge request is merged.
+func (m *mergeRequestMode) sourceBranchFor(ctx context.Context, location commitLocation) (string, error) {
+	if m.sourceBranch != "" {
+		return m.sourceBranch, nil
+	}
+	if sourceBranch, ok := m.sourceBranches[location]; ok {
+		return sourceBranch, nil
+	}
+
+	existing, _, err := m.c.MergeRequests.ListProjectMergeRequests(location.projectId, &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		TargetBranch: gitlab.String(location.branch),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("unable to list merge requests into %s: %w", location.branch, err)
+	}
+	var open *gitlab.MergeRequest
+	for _, mr := range existing {
+		if strings.HasPrefix(mr.SourceBranch, generatedBranchPrefix(location.branch)) && (open == nil || mr.IID > open.IID) {
+			open = mr
+		}
+	}
+
+	sourceBranch := generatedBranchPrefix(location.branch) + m.created.UTC().Format("20060102150405")
+	if open != nil {
+		logD(fmt.Sprintf("[PROVIDER] using open merge request !%d from %s", open.IID, open.SourceBranch))
+		sourceBranch = open.SourceBranch
+		m.mergeRequests[location] = open
+	}
+	m.sourceBranches[location] = sourceBranch
+	return sourceBranch, nil
+}
+
+// prepare sends the commit to the source branch, creating it from the start branch if it does not exist.
+// The returned branch is the branch the commit is based on, which is used to find conflicting files.
+func (m *mergeRequestMode) prepare(ctx context.Context, location commitLocation, opts *gitlab.CreateCommitOptions) (string, error) {
+	sourceBranch, err := m.sourceBranchFor(ctx, location)
+	if err != nil {
+		return "", err
+	}
+	opts.Branch = gitlab.String(sourceBranch)
+
+	if _, ok := m.mergeRequests[location]; ok {
+		return sourceBranch, nil
+	}
+
+	// the source branch might exist from an earlier run
+	_, err = m.repository.GetBranch(ctx, location.projectId, sourceBranch)
+	if err == nil {
+		return sourceBranch, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("unable to get source branch %s: %w", sourceBranch, err)
+	}
+
+	startBranch := m.startBranch
+	if startBranch == "" {
+		startBranch = location.branch
+	}
+	opts.StartBranch = gitlab.String(startBranch)
+	return startBranch, nil
+}
+
+// open returns the merge request from the source branch into the branch of the location, it is created if no open merge request exists
+func (m *mergeRequestMode) open(ctx context.Context, location commitLocation, commitMessage string) (*gitlab.MergeRequest, error) {
+	if mr, ok := m.mergeRequests[location]; ok {
+		return mr, nil
+	}
+
+	sourceBranch, err := m.sourceBranchFor(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	existing, _, err := m.c.MergeRequests.ListProjectMergeRequests(location.projectId, &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		SourceBranch: gitlab.String(sourceBranch),
+		TargetBranch: gitlab.String(location.branch),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list merge requests from %s: %w", sourceBranch, err)
+	}
+	if len(existing) > 0 {
+		logD(fmt.Sprintf("[PROVIDER] using open merge request !%d from %s", existing[0].IID, sourceBranch))
+		m.mergeRequests[location] = existing[0]
+		return existing[0], nil
+	}
+
+	description := m.description
+	if description == "" {
+		description = commitMessage
+	}
+	opts := &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.String(m.title),
+		Description:        gitlab.String(description),
+		SourceBranch:       gitlab.String(sourceBranch),
+		TargetBranch:       gitlab.String(location.branch),
+		RemoveSourceBranch: gitlab.Bool(m.removeSourceBranch),
+		AssigneeIDs:        m.assigneeIDs,
+		ReviewerIDs:        m.reviewerIDs,
+	}
+	if len(m.labels) > 0 {
+		opts.Labels = gitlab.Labels(m.labels)
+	}
+
+	mr, _, err := m.c.MergeRequests.CreateMergeRequest(location.projectId, opts, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create merge request from %s into %s: %w", sourceBranch, location.branch, err)
+	}
+	logD(fmt.Sprintf("[PROVIDER] created merge request !%d from %s into %s", mr.IID, sourceBranch, location.branch))
+	m.mergeRequests[location] = mr
+
+	if m.mergeWhenPipelineSucceeds {
+		m.enableMergeWhenPipelineSucceeds(ctx, location.projectId, mr)
+	}
+
+	return mr, nil
+}
+
+// enableMergeWhenPipelineSucceeds sets the merge request to be merged when the pipeline succeeds.
+// The commit and merge request have been created at this point, so a failure is only logged.
+func (m *mergeRequestMode) enableMergeWhenPipelineSucceeds(ctx context.Context, projectId string, mr *gitlab.MergeRequest) {
+	if err := mergeWhenPipelineSucceeds(ctx, m.c, m.retry, projectId, mr.IID, "", m.removeSourceBranch); err != nil {
+		log.Printf("[WARN] unable to enable merge when pipeline succeeds for merge request !%d: %s", mr.IID, err)
+	}
+}
+
+// mergeWhenPipelineSucceeds sets the merge request to be merged when the pipeline succeeds, GitLab rejects the merge if sha is set and is not the head of the merge request.
+// The pipeline is created in the background, so GitLab rejects the request until it exists, it is retried with policy.
+func mergeWhenPipelineSucceeds(ctx context.Context, c *gitlab.Client, policy retryPolicy, projectId string, iid int, sha string, removeSourceBranch bool) error {
+	opts := &gitlab.AcceptMergeRequestOptions{
+		MergeWhenPipelineSucceeds: gitlab.Bool(true),
+		ShouldRemoveSourceBranch:  gitlab.Bool(removeSourceBranch),
+	}
+	if sha != "" {
+		opts.SHA = gitlab.String(sha)
+	}
+
+	return retry.Do(
+		func() error {
+			_, _, err := c.MergeRequests.AcceptMergeRequest(projectId, iid, opts, gitlab.WithContext(ctx))
+			return err
+		},
+		policy.options(ctx)...,
+	)
+}
+
+// mergeRequestBranch returns the branch to read the resource from.
+// Changes are only on the source branch until the merge request is merged, the branch of the resource is used for every other state.
+func mergeRequestBranch(ctx context.Context, d resourceGetter, client *client, projectId, branch string) (string, error) {
+	iid := d.Get("merge_request_iid").(int)
+	// the git backend does not open merge requests, a merge request in the state is from before the backend was changed
+	if iid == 0 || client.gitlab == nil {
+		return branch, nil
+	}
+
+	mr, resp, err := client.gitlab.MergeRequests.GetMergeRequest(projectId, iid, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return branch, nil
+		}
+		return "", fmt.Errorf("unable to get merge request !%d: %w", iid, err)
+	}
+	if mr.State == "opened" || mr.State == "locked" {
+		return mr.SourceBranch, nil
+	}
+	return branch, nil
+}
+
+// setMergeRequest sets the merge request attributes, mergeRequest is nil when not using merge requests
+func setMergeRequest(d *schema.ResourceData, mergeRequest *gitlab.MergeRequest) {
+	if mergeRequest == nil {
+		d.Set("merge_request_iid", 0)
+		d.Set("merge_request_url", "")
+		return
+	}
+	d.Set("merge_request_iid", mergeRequest.IID)
+	d.Set("merge_request_url", mergeRequest.WebURL)
+}
+
+func toInts(v interface{}) []int {
+	var i []int
+	for _, e := range v.([]interface{}) {
+		i = append(i, e.(int))
+	}
+	return i
+}
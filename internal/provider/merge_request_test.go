@@ -0,0 +1,289 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestNewMergeRequestMode(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+		"commit_message": "Update files",
+		"start_branch":   "develop",
+	})
+	assert.Nil(t, newMergeRequestMode(d, nil, nil, retryPolicy{}), "merge request mode should be disabled without the block")
+
+	d = schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+		"commit_message": "Update files",
+		"start_branch":   "develop",
+		"merge_request": []interface{}{map[string]interface{}{
+			"labels":       []interface{}{"terraform"},
+			"reviewer_ids": []interface{}{1, 2},
+		}},
+	})
+	m := newMergeRequestMode(d, nil, nil, retryPolicy{})
+	assert.Equal(t, "Update files", m.title)
+	assert.Equal(t, "develop", m.startBranch)
+	assert.Equal(t, []string{"terraform"}, m.labels)
+	assert.Equal(t, []int{1, 2}, m.reviewerIDs)
+	assert.True(t, m.removeSourceBranch)
+	assert.False(t, m.mergeWhenPipelineSucceeds)
+}
+
+func TestMergeRequestModeCreatesBranchAndMergeRequest(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		created  map[string]interface{}
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v4/projects/1/repository/branches/terraform-provider-gitlabcommit/main-20261016120000":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 Branch Not Found"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/1/merge_requests":
+			fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/1/merge_requests":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			fmt.Fprint(w, `{"iid":7,"web_url":"https://gitlab.example.com/mr/7"}`)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v4/projects/1/merge_requests/7/merge":
+			fmt.Fprint(w, `{"iid":7}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	m := &mergeRequestMode{
+		c:                         c,
+		repository:                newGitlabCommitter(c),
+		title:                     "Update files",
+		labels:                    []string{"terraform"},
+		removeSourceBranch:        true,
+		mergeWhenPipelineSucceeds: true,
+		created:                   time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		sourceBranches:            map[commitLocation]string{},
+		mergeRequests:             map[commitLocation]*gitlab.MergeRequest{},
+	}
+	location := commitLocation{projectId: "1", branch: "main"}
+
+	opts := &gitlab.CreateCommitOptions{}
+	baseBranch, err := m.prepare(context.Background(), location, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", baseBranch)
+	assert.Equal(t, "terraform-provider-gitlabcommit/main-20261016120000", *opts.Branch)
+	assert.Equal(t, "main", *opts.StartBranch)
+
+	mr, err := m.open(context.Background(), location, "Update files\n\n- add a")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, mr.IID)
+	assert.Equal(t, "https://gitlab.example.com/mr/7", mr.WebURL)
+	assert.Equal(t, "Update files", created["title"])
+	assert.Equal(t, "Update files\n\n- add a", created["description"])
+	assert.Equal(t, "terraform-provider-gitlabcommit/main-20261016120000", created["source_branch"])
+	assert.Equal(t, "main", created["target_branch"])
+	assert.Equal(t, "terraform", created["labels"])
+	assert.Equal(t, true, created["remove_source_branch"])
+
+	// the next batch is committed to the existing source branch and merge request
+	opts = &gitlab.CreateCommitOptions{}
+	baseBranch, err = m.prepare(context.Background(), location, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "terraform-provider-gitlabcommit/main-20261016120000", baseBranch)
+	assert.Nil(t, opts.StartBranch)
+
+	mr, err = m.open(context.Background(), location, "Update files")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, mr.IID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, requests, "PUT /api/v4/projects/1/merge_requests/7/merge")
+	var posts int
+	for _, r := range requests {
+		if r == "POST /api/v4/projects/1/merge_requests" {
+			posts++
+		}
+	}
+	assert.Equal(t, 1, posts, "the merge request should only be created once")
+}
+
+func TestMergeRequestModeReusesBranchAndMergeRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v4/":
+			// go-gitlab requests the API root to configure its rate limiter
+		case r.URL.Path == "/api/v4/projects/1/repository/branches/update-files":
+			fmt.Fprint(w, `{"name":"update-files"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/1/merge_requests":
+			assert.Equal(t, "update-files", r.URL.Query().Get("source_branch"))
+			assert.Equal(t, "main", r.URL.Query().Get("target_branch"))
+			fmt.Fprint(w, `[{"iid":3,"web_url":"https://gitlab.example.com/mr/3"}]`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	m := &mergeRequestMode{
+		c:             c,
+		repository:    newGitlabCommitter(c),
+		sourceBranch:  "update-files",
+		startBranch:   "develop",
+		mergeRequests: map[commitLocation]*gitlab.MergeRequest{},
+	}
+	location := commitLocation{projectId: "1", branch: "main"}
+
+	opts := &gitlab.CreateCommitOptions{}
+	baseBranch, err := m.prepare(context.Background(), location, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "update-files", baseBranch)
+	assert.Equal(t, "update-files", *opts.Branch)
+	assert.Nil(t, opts.StartBranch, "an existing source branch should not be recreated")
+
+	mr, err := m.open(context.Background(), location, "Update files")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, mr.IID)
+}
+
+func TestMergeRequestBranch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/projects/1/merge_requests/1":
+			fmt.Fprint(w, `{"iid":1,"state":"opened","source_branch":"update-files"}`)
+		case "/api/v4/projects/1/merge_requests/2":
+			fmt.Fprint(w, `{"iid":2,"state":"merged","source_branch":"update-files"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 Not found"}`)
+		}
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	c := &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient)}
+
+	tests := []struct {
+		name     string
+		iid      int
+		expected string
+	}{
+		{name: "without merge request", iid: 0, expected: "main"},
+		{name: "open merge request", iid: 1, expected: "update-files"},
+		{name: "merged merge request", iid: 2, expected: "main"},
+		{name: "deleted merge request", iid: 3, expected: "main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{})
+			d.Set("merge_request_iid", tt.iid)
+
+			branch, err := mergeRequestBranch(context.Background(), d, c, "1", "main")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, branch)
+		})
+	}
+}
+
+func TestMergeWhenPipelineSucceedsRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v4/projects/1/merge_requests/7/merge" {
+			// go-gitlab requests the API root to configure its rate limiter
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// GitLab rejects the merge until the pipeline exists
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"message":"405 Method Not Allowed"}`)
+	}))
+	defer server.Close()
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	policy := retryPolicy{maxAttempts: 3, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+
+	err = mergeWhenPipelineSucceeds(context.Background(), c, policy, "1", 7, "", false)
+	assert.Error(t, err)
+	mu.Lock()
+	assert.Equal(t, 3, attempts, "the attempts should be given by the retry policy")
+	attempts = 0
+	mu.Unlock()
+
+	// the retries stop when the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy.minBackoff, policy.maxBackoff = time.Hour, time.Hour
+	start := time.Now()
+	err = mergeWhenPipelineSucceeds(ctx, c, policy, "1", 7, "", false)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Zero(t, attempts)
+}
+
+func TestMergeRequestModeAppliesTwiceBeforeMerge(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	server.WriteFile("1", gitlabfake.DefaultBranch, "README.md", []byte("readme"))
+
+	attributes := map[string]interface{}{"merge_request": []interface{}{map[string]interface{}{"title": "Update files"}}}
+	p, _ := testProvider(t, server, attributes)
+	state, err := applyResource(p, "gitlabcommit_file", nil, map[string]interface{}{"file_path": "a.txt", "content": "one"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	iid := state.Attributes["merge_request_iid"]
+	assert.NotEqual(t, "0", iid)
+	_, ok := server.File("1", gitlabfake.DefaultBranch, "a.txt")
+	assert.False(t, ok, "the file should only be on the source branch")
+
+	// the next run generates another branch name, but commits to the source branch of the open merge request
+	time.Sleep(time.Second)
+	p, c := testProvider(t, server, attributes)
+	state, diags := p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), state, c)
+	if !assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags)) {
+		return
+	}
+	state, err = applyResource(p, "gitlabcommit_file", state, map[string]interface{}{"file_path": "a.txt", "content": "two"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, iid, state.Attributes["merge_request_iid"])
+	assert.Equal(t, "two", state.Attributes["content"])
+	assert.Equal(t, "false", state.Attributes["changed_outside"])
+}
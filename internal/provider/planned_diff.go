@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// maxDiffSize is the largest content, in bytes, shown as a diff in planned_diff
+const maxDiffSize = 256 * 1024
+
+// resourceGetter is implemented by both schema.ResourceData and schema.ResourceDiff
+type resourceGetter interface {
+	Get(key string) interface{}
+}
+
+// setPlannedDiff sets planned_diff to the diff between the file in the repository and the planned content of an updated file.
+// The file is fetched instead of using the state, so the diff is correct even if the state has not been refreshed.
+func setPlannedDiff(ctx context.Context, d *schema.ResourceDiff, client *client) error {
+	if client == nil || d.Id() == "" || !(d.HasChange("content") || d.HasChange("content_base64") || d.HasChange("content_sha256")) {
+		return nil
+	}
+	for _, key := range []string{"content", "content_base64", "source"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("planned_diff")
+		}
+	}
+
+	projectId, branch := resourceLocation(d, client)
+	branch, err := mergeRequestBranch(ctx, d, client, projectId, branch)
+	if err != nil {
+		return err
+	}
+
+	// the metadata is compared first, so the content is only loaded when a diff is shown
+	size, err := plannedSize(d)
+	if err != nil {
+		return err
+	}
+	filePath := d.Get("file_path").(string)
+	var (
+		header      string
+		currentSize int64
+	)
+	metaData, err := client.repository.GetFileMetaData(ctx, projectId, filePath, branch)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		metaData = nil
+		header = "# deleted outside of Terraform since the last apply\n"
+	case err != nil:
+		return fmt.Errorf("unable to get %s for planned_diff: %w", filePath, err)
+	default:
+		currentSize = int64(metaData.Size)
+		if commitID := d.Get("commit_id").(string); commitID != "" && metaData.LastCommitID != commitID {
+			header = fmt.Sprintf("# changed outside of Terraform by commit %s since the last apply\n", metaData.LastCommitID)
+		}
+		if metaData.SHA256 == d.Get("content_sha256").(string) {
+			// the repository already has the planned content
+			return d.SetNew("planned_diff", header)
+		}
+	}
+	if currentSize > maxDiffSize || size > maxDiffSize {
+		return d.SetNew("planned_diff", header+tooLargeDiff(filePath, currentSize, size))
+	}
+
+	planned, err := plannedContent(d)
+	if err != nil {
+		return err
+	}
+	var current []byte
+	if metaData != nil {
+		repositoryFile, err := client.repository.GetFile(ctx, projectId, filePath, branch)
+		if err != nil {
+			return fmt.Errorf("unable to get %s for planned_diff: %w", filePath, err)
+		}
+		if current, err = base64.StdEncoding.DecodeString(repositoryFile.Content); err != nil {
+			return fmt.Errorf("unable to decode content: %w", err)
+		}
+	}
+
+	diff, err := unifiedDiff(filePath, current, planned)
+	if err != nil {
+		return err
+	}
+	return d.SetNew("planned_diff", header+diff)
+}
+
+// plannedSize returns the size in bytes of the content the file will have after the apply, a source is not read
+func plannedSize(d *schema.ResourceDiff) (int64, error) {
+	if source := d.Get("source").(string); source != "" {
+		info, err := os.Stat(source)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read source: %w", err)
+		}
+		return info.Size(), nil
+	}
+	content, err := plannedContent(d)
+	return int64(len(content)), err
+}
+
+// plannedContent returns the content the file will have after the apply
+func plannedContent(d *schema.ResourceDiff) ([]byte, error) {
+	switch {
+	case d.Get("source").(string) != "":
+		content, err := os.ReadFile(d.Get("source").(string))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read source: %w", err)
+		}
+		return content, nil
+	case d.Get("content_base64").(string) != "":
+		content, err := base64.StdEncoding.DecodeString(d.Get("content_base64").(string))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode content_base64: %w", err)
+		}
+		return content, nil
+	default:
+		return []byte(d.Get("content").(string)), nil
+	}
+}
+
+// unifiedDiff returns the unified diff from current to planned, or a summary if either is binary or too large to show
+func unifiedDiff(filePath string, current, planned []byte) (string, error) {
+	if !utf8.Valid(current) || !utf8.Valid(planned) {
+		return fmt.Sprintf("Binary files a/%s and b/%s differ (%d -> %d bytes)\n", filePath, filePath, len(current), len(planned)), nil
+	}
+	if len(current) > maxDiffSize || len(planned) > maxDiffSize {
+		return tooLargeDiff(filePath, int64(len(current)), int64(len(planned))), nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(current),
+		B:        splitLines(planned),
+		FromFile: "a/" + filePath,
+		ToFile:   "b/" + filePath,
+		Context:  3,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to diff %s: %w", filePath, err)
+	}
+	return diff, nil
+}
+
+// tooLargeDiff is shown instead of the diff when either content is larger than maxDiffSize
+func tooLargeDiff(filePath string, currentSize, plannedSize int64) string {
+	return fmt.Sprintf("Files a/%s and b/%s differ, too large to show (%d -> %d bytes)\n", filePath, filePath, currentSize, plannedSize)
+}
+
+// splitLines splits the content into lines keeping the newlines.
+// A newline is added to a last line without one, so it is not joined with the next line of the diff.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
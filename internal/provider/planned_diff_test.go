@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []byte
+		planned  []byte
+		expected string
+	}{
+		{
+			name:     "changed line",
+			current:  []byte("a\nb\nc\n"),
+			planned:  []byte("a\nB\nc\n"),
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:     "created file",
+			planned:  []byte("a"),
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:     "binary file",
+			current:  []byte{0xff, 0xfe},
+			planned:  []byte("a"),
+			expected: "Binary files a/file.txt and b/file.txt differ (2 -> 1 bytes)\n",
+		},
+		{
+			name:     "too large file",
+			current:  []byte("a"),
+			planned:  []byte(strings.Repeat("a", maxDiffSize+1)),
+			expected: fmt.Sprintf("Files a/file.txt and b/file.txt differ, too large to show (1 -> %d bytes)\n", maxDiffSize+1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := unifiedDiff("file.txt", tt.current, tt.planned)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, diff)
+		})
+	}
+}
+
+func TestResourceFileDiffPlannedDiff(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetched []string
+	)
+	files := map[string]struct {
+		content string
+		size    int
+	}{
+		"file.txt": {content: "old\n", size: 4},
+		"same.txt": {content: "new\n", size: 4},
+		// only the size of the large file is served, its content must not be fetched
+		"large.txt": {size: 1024 * 1024},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		file, ok := files[strings.TrimPrefix(r.URL.Path, "/api/v4/projects/1/repository/files/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hash := sha256.Sum256([]byte(file.content))
+		w.Header().Set("X-Gitlab-Content-Sha256", hex.EncodeToString(hash[:]))
+		w.Header().Set("X-Gitlab-Last-Commit-Id", "other")
+		w.Header().Set("X-Gitlab-Size", strconv.Itoa(file.size))
+		if r.Method == http.MethodHead {
+			return
+		}
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"content":"%s","last_commit_id":"other"}`, base64.StdEncoding.EncodeToString([]byte(file.content)))
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	c := &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient), projectId: "1", branch: "main"}
+
+	largeSource := filepath.Join(t.TempDir(), "large.txt")
+	assert.NoError(t, os.WriteFile(largeSource, []byte(strings.Repeat("a\n", maxDiffSize)), 0o644))
+
+	tests := []struct {
+		name     string
+		filePath string
+		commitId string
+		source   string
+		expected string
+		fetched  bool
+	}{
+		{
+			name:     "unchanged outside of terraform",
+			filePath: "file.txt",
+			commitId: "other",
+			expected: "--- a/file.txt\n+++ b/file.txt\n@@ -1 +1 @@\n-old\n+new\n",
+			fetched:  true,
+		},
+		{
+			name:     "changed outside of terraform",
+			filePath: "file.txt",
+			commitId: "mine",
+			expected: "# changed outside of Terraform by commit other since the last apply\n--- a/file.txt\n+++ b/file.txt\n@@ -1 +1 @@\n-old\n+new\n",
+			fetched:  true,
+		},
+		{
+			name:     "deleted outside of terraform",
+			filePath: "deleted.txt",
+			commitId: "mine",
+			expected: "# deleted outside of Terraform since the last apply\n--- a/deleted.txt\n+++ b/deleted.txt\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			name:     "planned content already in the repository",
+			filePath: "same.txt",
+			commitId: "mine",
+			expected: "# changed outside of Terraform by commit other since the last apply\n",
+		},
+		{
+			name:     "repository file too large",
+			filePath: "large.txt",
+			commitId: "other",
+			expected: "Files a/large.txt and b/large.txt differ, too large to show (1048576 -> 4 bytes)\n",
+		},
+		{
+			name:     "source too large",
+			filePath: "file.txt",
+			commitId: "other",
+			source:   largeSource,
+			expected: fmt.Sprintf("Files a/file.txt and b/file.txt differ, too large to show (4 -> %d bytes)\n", 2*maxDiffSize),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			fetched = nil
+			mu.Unlock()
+
+			state := &terraform.InstanceState{
+				ID: tt.filePath,
+				Attributes: map[string]string{
+					"id":        tt.filePath,
+					"file_path": tt.filePath,
+					"content":   "old\n",
+					"commit_id": tt.commitId,
+				},
+			}
+			configuration := map[string]interface{}{
+				"file_path": tt.filePath,
+				"content":   "new\n",
+			}
+			if tt.source != "" {
+				delete(configuration, "content")
+				configuration["source"] = tt.source
+			}
+
+			diff, err := resourceGitlabCommit().Diff(context.Background(), state, terraform.NewResourceConfigRaw(configuration), c)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, diff.Attributes["planned_diff"].New)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tt.fetched, len(fetched) > 0, "content fetched: %v", fetched)
+		})
+	}
+}
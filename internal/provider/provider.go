@@ -2,16 +2,19 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/avast/retry-go"
 	"github.com/xanzy/go-gitlab"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func init() {
@@ -31,18 +34,90 @@ func init() {
 }
 
 func New() *schema.Provider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"gitlab_api_token": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				Sensitive:   true,
 				DefaultFunc: schema.EnvDefaultFunc("GITLAB_TOKEN", nil),
+				Description: "The GitLab API token. Required with the `gitlab` backend.",
 			},
 			"project_id": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("PROJECT_ID", nil),
+				Description: "The default project of the resources. Required with the `gitlab` backend, ignored by the `git` backend.",
+			},
+			"backend": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      backendGitlab,
+				ValidateFunc: validation.StringInSlice([]string{backendGitlab, backendGit}, false),
+				Description:  "Where the files are committed to. `gitlab` uses the GitLab API, `git` commits to the local repository configured in the `git` block without any network access, e.g. for disconnected environments.",
+			},
+			"git": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "The local repository of the `git` backend.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The path of a bare repository or of a working tree with a `.git` directory. The working tree is not updated by the commits. A missing repository is cloned from `remote_url` as a bare repository, or initialized empty without it.",
+						},
+						"remote_url": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The URL of the remote repository, e.g. `file:///mnt/usb/repo.git` or `ssh://git@example.com/repo.git`.",
+						},
+						"push": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Push the branch to `remote_url` after every commit. The local branch is reset if the push fails, so the failed commit is not pushed with the next commit to the branch.",
+						},
+					},
+				},
+			},
+			"base_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("GITLAB_BASE_URL", ""),
+				Description: "The base URL of a self-managed GitLab instance, e.g. `https://gitlab.example.com`. The `/api/v4/` suffix is added if missing. Defaults to gitlab.com.",
+			},
+			"ca_cert": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_cert_file"},
+				Description:   "PEM encoded CA certificate(s) used to verify the GitLab server in addition to the system roots.",
+			},
+			"ca_cert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_cert"},
+				Description:   "Path to a file with PEM encoded CA certificate(s) used to verify the GitLab server in addition to the system roots.",
+			},
+			"client_cert": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"client_key"},
+				Description:  "PEM encoded client certificate used for mutual TLS.",
+			},
+			"client_key": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				RequiredWith: []string{"client_cert"},
+				Description:  "PEM encoded private key for `client_cert`.",
+			},
+			"insecure_skip_verify": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Skip verification of the GitLab server certificate. Only use this for testing.",
 			},
 			"branch": {
 				Type:     schema.TypeString,
@@ -51,8 +126,64 @@ func New() *schema.Provider {
 				ForceNew: true,
 			},
 			"start_branch": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The branch the `merge_request` source branch is created from. Defaults to the branch of the resources.",
+			},
+			"merge_request": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Commit the changes to a new source branch and open a merge request into the branch of the resources instead of committing to it directly.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"source_branch": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The branch the changes are committed to. Defaults to the source branch of the open merge request into `<branch>` created by the provider, or a new `terraform-provider-gitlabcommit/<branch>-<timestamp>` if there is none.",
+						},
+						"title": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The title of the merge request. Defaults to the provider `commit_message`.",
+						},
+						"description": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The description of the merge request. Defaults to the message of the first commit.",
+						},
+						"labels": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "The labels of the merge request.",
+						},
+						"assignee_ids": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+							Description: "The IDs of the users assigned to the merge request.",
+						},
+						"reviewer_ids": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+							Description: "The IDs of the users reviewing the merge request.",
+						},
+						"remove_source_branch": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Remove the source branch when the merge request is merged.",
+						},
+						"merge_when_pipeline_succeeds": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Merge the merge request when the pipeline succeeds.",
+						},
+					},
+				},
 			},
 			"author_email": {
 				Type:     schema.TypeString,
@@ -63,177 +194,561 @@ func New() *schema.Provider {
 				Optional: true,
 			},
 			"commit_message": {
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "terraform-provider-gitlabcommit",
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "terraform-provider-gitlabcommit",
+				Description: "The header of the commit message. The `commit_message` of the resources in the commit are added below it as a bullet list, followed by a `Co-authored-by` trailer for every `co_author`.",
+			},
+			"conflict_strategy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      conflictStrategyFail,
+				ValidateFunc: validation.StringInSlice([]string{conflictStrategyFail, conflictStrategyOverwrite}, false),
+				Description:  "What to do when a file has been changed outside of Terraform since it was last read. `fail` rejects the commit, `overwrite` replaces the changes.",
+			},
+			"dry_run": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("GITLABCOMMIT_DRY_RUN", false),
+				Description: "Write the commits to `dry_run_output` instead of creating them. No merge requests are opened and the state is updated as if the commits were created, so use a state that is discarded afterwards. Defaults to `GITLABCOMMIT_DRY_RUN`.",
+			},
+			"dry_run_output": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("GITLABCOMMIT_DRY_RUN_OUTPUT", ""),
+				Description: "Path to the file the commits are written to in `dry_run`, with one JSON document per commit containing the project, the commit options and a summary of the actions: the number of files and actions, and the lines and bytes of the new content. The summary is not a diff against the current files. The commits are logged on the INFO level if empty. Defaults to `GITLABCOMMIT_DRY_RUN_OUTPUT`.",
 			},
 			"debounce_time": {
-				Type:        schema.TypeInt,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      200,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "How long the provider should wait for the resources before sending the commit. Value is given in milliseconds. Only used as a fallback when `expected_changes` is set.",
+			},
+			"retry": {
+				Type:        schema.TypeList,
 				Optional:    true,
-				Default:     200,
-				Description: "How long the provider should wait for the resources before sending the commit. Value is given in milliseconds.",
+				MaxItems:    1,
+				Description: "How failed requests to GitLab are retried. Requests failing with a network error or one of `retryable_status_codes` are retried with an exponential backoff, or after the time given by the `Retry-After` header of the response. A commit which might have been created by GitLab is only sent again if the head of the branch shows it was not. Retries stop when Terraform is interrupted.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"max_attempts": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      5,
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "The number of attempts including the first request.",
+						},
+						"min_backoff": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      1000,
+							ValidateFunc: validation.IntAtLeast(0),
+							Description:  "How long to wait after the first failed attempt in milliseconds. The wait is doubled for every attempt.",
+						},
+						"max_backoff": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      30000,
+							ValidateFunc: validation.IntAtLeast(0),
+							Description:  "The longest wait between two attempts in milliseconds, not counting `jitter`.",
+						},
+						"jitter": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      500,
+							ValidateFunc: validation.IntAtLeast(0),
+							Description:  "The largest random wait in milliseconds added to the backoff, so concurrent requests are not retried at the same time.",
+						},
+						"retryable_status_codes": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+							Description: "The HTTP status codes retried. Defaults to `[409, 429, 502, 503]`.",
+						},
+					},
+				},
+			},
+			"max_requests_per_second": {
+				Type:         schema.TypeFloat,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.FloatAtLeast(0),
+				Description:  "The largest number of requests per second sent to GitLab by all resources. The rate is lowered further when the `RateLimit-Remaining` and `RateLimit-Reset` headers of GitLab show that fewer requests remain. Unlimited when `0`.",
+			},
+			"max_concurrent_requests": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "The largest number of requests sent to GitLab at the same time by all resources. Unlimited when `0`.",
+			},
+			"max_actions_per_commit": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "The largest number of file actions in one commit. Larger batches are split into several commits, each created on top of the previous one. The actions of a resource are never split, so a `gitlabcommit_directory` with more actions is committed alone. Unlimited when `0`.",
+			},
+			"max_commit_bytes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "The largest size in bytes of the JSON encoded file actions in one commit, to stay below the request size limit of the GitLab instance. Larger batches are split like with `max_actions_per_commit`. Unlimited when `0`.",
+			},
+			"expected_changes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "A number you keep up to date by hand: the commit is sent as soon as this many `gitlabcommit_file` and `gitlabcommit_directory` changes (creates, updates and deletes) and `gitlabcommit_merge_request` creates have been received, instead of waiting for `debounce_time`. The provider does not know the plan, so this is not a barrier waiting for all planned changes: a wrong value is not detected. Too high a value delays the commit by `debounce_time`, too low a value sends the changes arriving after the expected ones in a separate commit after `debounce_time`. An update that does not change the repository, e.g. of `commit_message` only, is not counted. Must not be larger than the Terraform `-parallelism` to end up in a single commit.",
 			},
 		},
-		ConfigureContextFunc: configure,
 		ResourcesMap: map[string]*schema.Resource{
-			"gitlabcommit_file": resourceGitlabCommit(),
+			"gitlabcommit_file":          resourceGitlabCommit(),
+			"gitlabcommit_directory":     resourceGitlabCommitDirectory(),
+			"gitlabcommit_merge_request": resourceGitlabCommitMergeRequest(),
 		},
 	}
 
+	// the actionSyncronizer of the previous configuration is stopped when the provider is configured again, e.g. by tests
+	var configured *client
+	p.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		if configured != nil {
+			configured.close()
+			configured = nil
+		}
+		meta, diags := configure(ctx, d)
+		configured, _ = meta.(*client)
+		return meta, diags
+	}
+
+	return p
+}
+
+// errProviderStopped is returned to the resources whose actions were not committed before the actionSyncronizer stopped
+var errProviderStopped = errors.New("the provider was stopped before the changes were committed")
+
+// commitLocation is the project and branch a commit is created in
+type commitLocation struct {
+	projectId string
+
+	branch string
+}
+
+// commitRequest is sent from a resource to the actionSyncronizer, all actions in a request end up in the same commit
+type commitRequest struct {
+	// id identifies the resource in the logs
+	id string
+
+	// reply receives the one response to the request, it is buffered so the actionSyncronizer never waits for the resource
+	reply chan *responseSync
+
+	// location is where the actions are committed, requests for different locations are committed separately
+	location commitLocation
+
+	// author is the author of the commit, requests with different authors are committed separately
+	author commitAuthor
+
+	// message is added to the commit message as a bullet point, it can be empty
+	message string
+
+	// coAuthor is added to the commit message as a Co-authored-by trailer in the "Name <email>" format, it can be empty
+	coAuthor string
+
+	actions []*gitlab.CommitActionOptions
 }
 
 // responseSync is the response sent from actionSyncronizer
 type responseSync struct {
-	// filePath is used to tell the resource that they can exit if the filePath is theirs
-	filePath string
+	// commit is the commit containing the actions of the request, it is nil if the commit failed
+	commit *gitlab.Commit
+
+	// mergeRequest is the merge request containing the commit, it is nil if not using merge requests
+	mergeRequest *gitlab.MergeRequest
 
-	// err will only be received by the halted resource
+	// err is the result of the commit containing the actions of the request
 	err error
 }
 
 type client struct {
+	// gitlab is used for the merge requests
 	gitlab *gitlab.Client
 
+	// repository is where the files are committed to and read from
+	repository Committer
+
+	// projectId is the default project of the resources
 	projectId string
 
+	// branch is the default branch of the resources
 	branch string
 
-	actionCh chan<- *gitlab.CommitActionOptions
+	// author is the default author of the commits
+	author commitAuthor
+
+	conflictStrategy string
+
+	// dryRun is set when commits are written to a file or the log instead of being created
+	dryRun bool
+
+	// retry is the policy for retrying requests which are expected to fail until GitLab has caught up, e.g. merging when the pipeline succeeds
+	retry retryPolicy
+
+	// reads skips fetching files that have not changed since the last read
+	reads *readCache
+
+	actionCh chan<- *commitRequest
 
-	responseSyncCh chan *responseSync
+	// done is closed when the actionSyncronizer has stopped, no requests are received afterwards
+	done <-chan struct{}
+
+	// stop cancels the context of the actionSyncronizer
+	stop context.CancelFunc
+}
+
+// close stops the actionSyncronizer and waits until it has finished, the requests that have not been committed are rejected with errProviderStopped
+func (c *client) close() {
+	c.stop()
+	<-c.done
 }
 
 func configure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 	var (
-		actionCh       = make(chan *gitlab.CommitActionOptions)
-		responseSyncCh = make(chan *responseSync)
+		actionCh     = make(chan *commitRequest)
+		gitlabClient *gitlab.Client
+		repository   Committer
+		err          error
 	)
 
-	gitlabClient, err := gitlab.NewClient(d.Get("gitlab_api_token").(string))
+	switch d.Get("backend").(string) {
+	case backendGit:
+		repository, err = newGitBackend(ctx, d)
+	default:
+		if gitlabClient, err = newGitlabClient(d); err == nil {
+			repository = newGitlabCommitter(gitlabClient)
+		}
+	}
 	if err != nil {
 		return nil, diag.FromErr(err)
 	}
 
-	go handleResources(d, gitlabClient, actionCh, responseSyncCh)
+	var dryRunner *dryRun
+	if d.Get("dry_run").(bool) {
+		if dryRunner, err = newDryRun(d.Get("dry_run_output").(string)); err != nil {
+			return nil, diag.FromErr(err)
+		}
+		logD("dry run enabled, no commits will be created")
+	}
+
+	// the context of configure ends when it returns, the stop context is cancelled when Terraform is interrupted
+	stopCtx, ok := schema.StopContext(ctx)
+	if !ok {
+		stopCtx = context.Background()
+	}
+	syncCtx, stop := context.WithCancel(stopCtx)
+	reads := newReadCache(repository)
+	done := handleResources(syncCtx, d, gitlabClient, repository, dryRunner, reads, actionCh)
 
 	logD("done configuring provider")
 	return &client{
-		gitlab:         gitlabClient,
-		projectId:      d.Get("project_id").(string),
-		branch:         d.Get("branch").(string),
-		actionCh:       actionCh,
-		responseSyncCh: responseSyncCh,
+		gitlab:           gitlabClient,
+		repository:       repository,
+		projectId:        d.Get("project_id").(string),
+		branch:           d.Get("branch").(string),
+		author:           commitAuthor{name: d.Get("author_name").(string), email: d.Get("author_email").(string)},
+		conflictStrategy: d.Get("conflict_strategy").(string),
+		dryRun:           dryRunner != nil,
+		retry:            newRetryPolicy(d),
+		reads:            reads,
+		actionCh:         actionCh,
+		done:             done,
+		stop:             stop,
 	}, nil
 
 }
 
-func handleResources(d *schema.ResourceData, c *gitlab.Client, actionCh <-chan *gitlab.CommitActionOptions, respond chan<- *responseSync) {
-	duration := time.Duration(d.Get("debounce_time").(int))
-	debounceDuration := duration * time.Millisecond
-	doCommit := func(actions []*gitlab.CommitActionOptions) error {
-		return sendCommitActions(d.Get("project_id").(string), c, &gitlab.CreateCommitOptions{
-			Actions:       actions,
-			Branch:        gitlab.String(d.Get("branch").(string)),
-			AuthorEmail:   gitlab.String(d.Get("author_email").(string)),
-			AuthorName:    gitlab.String(d.Get("author_name").(string)),
-			CommitMessage: gitlab.String(d.Get("commit_message").(string)),
-		})
+func newGitlabClient(d *schema.ResourceData) (*gitlab.Client, error) {
+	if d.Get("gitlab_api_token").(string) == "" || d.Get("project_id").(string) == "" {
+		return nil, fmt.Errorf("gitlab_api_token and project_id are required with the %s backend", backendGitlab)
+	}
+
+	httpClient, err := newHTTPClient(d)
+	if err != nil {
+		return nil, err
+	}
+	// the retries are done by the retryTransport of the HTTP client
+	opts := []gitlab.ClientOptionFunc{gitlab.WithHTTPClient(httpClient), gitlab.WithoutRetries()}
+
+	baseURL := d.Get("base_url").(string)
+	if baseURL != "" {
+		opts = append(opts, gitlab.WithBaseURL(baseURL))
+	}
+
+	c, err := gitlab.NewClient(d.Get("gitlab_api_token").(string), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseURL != "" {
+		// we fail early on a wrong base URL instead of failing on the first commit
+		if _, _, err := c.Version.GetVersion(); err != nil {
+			return nil, fmt.Errorf("unable to reach the GitLab API at %s: %w", c.BaseURL(), err)
+		}
+		logD("validated GitLab API at " + c.BaseURL().String())
+	}
+
+	return c, nil
+}
+
+// handleResources starts the actionSyncronizer in the background.
+// The provider configuration is read before starting it since schema.ResourceData is not safe for concurrent use.
+// The commits are sent to repository, or written by dryRunner if it is set. The actionSyncronizer stops when ctx is done.
+// The branches committed to are invalidated in reads. The returned channel is closed when the actionSyncronizer has stopped.
+func handleResources(ctx context.Context, d *schema.ResourceData, c *gitlab.Client, repository Committer, dryRunner *dryRun, reads *readCache, actionCh <-chan *commitRequest) <-chan struct{} {
+	var (
+		debounceDuration = time.Duration(d.Get("debounce_time").(int)) * time.Millisecond
+		commitHeader     = d.Get("commit_message").(string)
+		expectedChanges  = d.Get("expected_changes").(int)
+		retryPolicy      = newRetryPolicy(d)
+		limits           = commitLimits{
+			maxActions: d.Get("max_actions_per_commit").(int),
+			maxBytes:   d.Get("max_commit_bytes").(int),
+		}
+	)
+
+	mergeRequests := newMergeRequestMode(d, c, repository, retryPolicy)
+
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		var (
+			actions    = batch.actions()
+			message    = batch.message(commitHeader)
+			baseBranch = batch.location.branch
+			opts       = &gitlab.CreateCommitOptions{
+				Actions:       actions,
+				Branch:        gitlab.String(batch.location.branch),
+				AuthorEmail:   gitlab.String(batch.author.email),
+				AuthorName:    gitlab.String(batch.author.name),
+				CommitMessage: gitlab.String(message),
+			}
+		)
+		if len(actions) == 0 {
+			logD("[PROVIDER] skipping commit to " + batch.location.projectId + " on " + batch.location.branch + " due to no actions")
+			return nil, nil, nil
+		}
+
+		if mergeRequests != nil {
+			var err error
+			if baseBranch, err = mergeRequests.prepare(ctx, batch.location, opts); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if batch.parent != "" && dryRunner == nil {
+			// GitLab only uses start_sha to create a branch, so the commits after the first of a split batch are created on the head of the branch.
+			// The batch fails instead of including changes pushed by others in between its commits.
+			branch, err := repository.GetBranch(ctx, batch.location.projectId, *opts.Branch)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to get the head of %s: %w", *opts.Branch, err)
+			}
+			if branch.Commit.ID != batch.parent {
+				return nil, nil, fmt.Errorf("branch %s was changed by commit %s after commit %s of the batch was created", *opts.Branch, branch.Commit.ID, batch.parent)
+			}
+		}
+
+		commit, err := sendCommitActions(ctx, retryPolicy, batch.location.projectId, repository, dryRunner, opts)
+		// the branch might have changed even if the commit failed
+		reads.invalidate(commitLocation{projectId: batch.location.projectId, branch: *opts.Branch})
+		if isConflict(err) {
+			return nil, nil, findConflicts(ctx, batch.location.projectId, baseBranch, repository, actions, err)
+		}
+		if err != nil || mergeRequests == nil || dryRunner != nil {
+			return commit, nil, err
+		}
+
+		mr, err := mergeRequests.open(ctx, batch.location, message)
+		return commit, mr, err
 	}
 
-	actionSyncronizer(debounceDuration, actionCh, respond, doCommit)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		actionSyncronizer(ctx, debounceDuration, expectedChanges, limits, actionCh, doCommit)
+	}()
+	return done
 }
 
-// actionSyncronizer will collect all gitlab.CommitActionOptions and return them in a slice when time since last resource received is bigger than debounce time.
-// The done channel is used to halt the first resource to avoid Terraform from exiting.
-func actionSyncronizer(debounce time.Duration, actionCh <-chan *gitlab.CommitActionOptions, respond chan<- *responseSync, doCommit func(actions []*gitlab.CommitActionOptions) error) {
+// actionSyncronizer will collect all commitRequests and commit their actions as soon as the expected number of requests has been received.
+// When expected is unknown (zero) or more requests than expected are received, the commit is sent when time since last resource received is bigger than debounce time.
+// The batch is committed as one commit per location (project and branch) and author, in the order they were first received.
+// A commit exceeding the limits is split into several commits, each created on top of the previous one. The commits after a failed one are not sent.
+// No resource is acknowledged before the commit containing its actions has finished, every resource then receives the result of the commit containing its actions on the reply channel of its request.
+// It returns when ctx is done: a commit in progress is finished and reports its result, the requests that have not been committed yet are rejected with errProviderStopped.
+func actionSyncronizer(ctx context.Context, debounce time.Duration, expected int, limits commitLimits, actionCh <-chan *commitRequest, doCommit func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error)) {
 	var (
-		actionsToSend  []*gitlab.CommitActionOptions
-		haltedResource string
+		requestsToSend []*commitRequest
+		committed      int
 		timeNow        = time.Now()
 		ticker         = time.NewTicker(debounce / 2)
 	)
 
 	defer ticker.Stop()
 
-	for {
-		select {
-		case action := <-actionCh:
-			logD("[PROVIDER] received filePath for: " + *action.FilePath)
-			timeNow = time.Now()
-			actionsToSend = append(actionsToSend, action)
-
-			if haltedResource == "" {
-				logD("[PROVIDER] halting resource with filepath: " + *action.FilePath)
-				// we halt this resource to avoid terraform exiting
-				haltedResource = *action.FilePath
-			} else {
-				logD("[PROVIDER] sending done to filepath: " + *action.FilePath)
-				// but we let the other resource exit
-				respond <- &responseSync{
-					filePath: *action.FilePath,
-					err:      nil,
+	commit := func() {
+		if ctx.Err() != nil {
+			// the requests are rejected when the stop is received
+			return
+		}
+		for _, batch := range groupRequests(requestsToSend) {
+			var (
+				parts  = limits.split(batch)
+				parent string
+				failed error
+			)
+			for _, part := range parts {
+				var (
+					commit *gitlab.Commit
+					mr     *gitlab.MergeRequest
+					err    = failed
+				)
+				if failed == nil {
+					part.parent = parent
+					commit, mr, err = doCommit(part)
 				}
-			}
-			logD("[PROVIDER] total received actions: " + strconv.Itoa(len(actionsToSend)))
-		case <-ticker.C:
-			logD("[PROVIDER] new tick")
-			if time.Since(timeNow) > debounce {
-				if len(actionsToSend) == 0 {
-					logD("[PROVIDER] no actions received, skipping send and sleeping for 3 seconds")
-					time.Sleep(3 * time.Second)
-					timeNow = time.Now()
-					continue
+
+				switch {
+				case failed != nil:
+					logD(fmt.Sprintf("[PROVIDER] skipping commit %d of %d to %s on %s since an earlier commit failed", part.part, part.parts, batch.location.projectId, batch.location.branch))
+				case err != nil && part.parts > 1:
+					log.Printf("[WARN] sending commit %d of %d to %s on %s failed, the earlier commits were created: %s", part.part, part.parts, batch.location.projectId, batch.location.branch, err)
+					err = fmt.Errorf("commit %d of %d failed, the earlier commits were created: %w", part.part, part.parts, err)
+					failed = fmt.Errorf("not committed since commit %d of %d failed: %w", part.part, part.parts, err)
+				case err != nil:
+					logD("[PROVIDER] sending commit to " + batch.location.projectId + " on " + batch.location.branch + " failed: " + err.Error())
+				case part.parts > 1:
+					log.Printf("[INFO] sent commit %d of %d with %d actions to %s on %s", part.part, part.parts, len(part.actions()), batch.location.projectId, batch.location.branch)
+				default:
+					logD("[PROVIDER] successfully sent commit to " + batch.location.projectId + " on " + batch.location.branch)
+				}
+				if commit != nil {
+					parent = commit.ID
 				}
 
-				logD("[PROVIDER] sending commits due to time since last received action is greater than debounce time")
-				if err := doCommit(actionsToSend); err != nil {
-					logD("[PROVIDER] sending commit failed: " + err.Error())
-					respond <- &responseSync{
-						filePath: haltedResource,
-						err:      err,
+				// every resource in the commit gets the same result
+				for _, request := range part.requests {
+					request.reply <- &responseSync{
+						commit:       commit,
+						mergeRequest: mr,
+						err:          err,
 					}
 				}
+			}
+		}
+		logD("[PROVIDER] done sending commits - preparing for more resources")
 
-				logD("[PROVIDER] successfully sent commits - preparing for more resources")
-				respond <- &responseSync{
-					filePath: haltedResource,
-					err:      nil,
-				}
+		// cleaning up sent commits in case more resources are coming in
+		committed += len(requestsToSend)
+		requestsToSend = nil
+		timeNow = time.Now()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(requestsToSend) > 0 {
+				log.Printf("[WARN] provider stopped, rejecting %d requests that have not been committed", len(requestsToSend))
+			}
+			for _, request := range requestsToSend {
+				request.reply <- &responseSync{err: errProviderStopped}
+			}
+			logD("[PROVIDER] stopped")
+			return
+		case request := <-actionCh:
+			logD("[PROVIDER] received request for: " + request.id)
+			timeNow = time.Now()
+			requestsToSend = append(requestsToSend, request)
+			logD("[PROVIDER] total received requests: " + strconv.Itoa(len(requestsToSend)))
 
-				// cleaning up sent commits in case more resources are coming in
-				haltedResource = ""
-				actionsToSend = nil
-				timeNow = time.Now()
+			if expected > 0 && committed >= expected && len(requestsToSend) == 1 {
+				log.Printf("[WARN] received more than the %d expected changes, the remaining changes are committed after the debounce time", expected)
+			}
+			if expected > committed && len(requestsToSend) == expected-committed {
+				logD("[PROVIDER] sending commits due to all " + strconv.Itoa(expected) + " expected requests are received")
+				commit()
+			}
+		case <-ticker.C:
+			if len(requestsToSend) > 0 && time.Since(timeNow) > debounce {
+				logD("[PROVIDER] sending commits due to time since last received action is greater than debounce time")
+				commit()
 			}
 		}
 	}
 }
 
-func sendCommitActions(projectId string, c *gitlab.Client, opts *gitlab.CreateCommitOptions) error {
+// sendCommitActions creates one commit with all actions in the repository, or writes it with dryRunner if set. The returned commit is nil if no commit was created.
+// Failed requests are retried by the HTTP client, a commit rejected since the branch was updated at the same time is retried with the policy.
+func sendCommitActions(ctx context.Context, policy retryPolicy, projectId string, repository Committer, dryRunner *dryRun, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error) {
 	if len(opts.Actions) == 0 {
 		logD("skipping commit due no actions")
-		return nil
+		return nil, nil
+	}
+	if dryRunner != nil {
+		return dryRunner.write(projectId, opts)
 	}
 	logD(fmt.Sprintf("creating commits for %d actions", len(opts.Actions)))
 
-	return retry.Do(
+	// parent is the head before the commit, a failed attempt is only sent again if it did not create the commit
+	var parent string
+	if opts.StartSHA != nil {
+		parent = *opts.StartSHA
+	} else if branch, err := repository.GetBranch(ctx, projectId, *opts.Branch); err == nil {
+		parent = branch.Commit.ID
+	}
+
+	var (
+		commit     *gitlab.Commit
+		notCreated bool
+	)
+	err := retry.Do(
 		func() error {
-			_, resp, err := c.Commits.CreateCommit(projectId, opts)
-			if err != nil {
-				if strings.Contains(err.Error(), "A file with this name already exists") {
+			var err error
+			notCreated = false
+			commit, err = repository.CreateCommit(ctx, projectId, opts)
+			if err == nil {
+				return nil
+			}
+			if parent != "" && policy.ambiguous(err) {
+				head, headErr := repository.GetBranch(ctx, projectId, *opts.Branch)
+				switch {
+				case headErr == nil && createdCommit(head.Commit, parent, opts):
+					logD(fmt.Sprintf("commit %s was created by the failed attempt", head.Commit.ID))
+					commit = head.Commit
 					return nil
+				case headErr == nil && head.Commit.ID == parent:
+					notCreated = true
+				case errors.Is(headErr, os.ErrNotExist) && opts.StartSHA != nil:
+					notCreated = true
 				}
-				return fmt.Errorf("unable to create commit: status message %s: status code %d: %w", resp.Status, resp.StatusCode, err)
 			}
-			return nil
+			return fmt.Errorf("unable to create commit: %w", err)
 		},
-		retry.RetryIf(func(err error) bool {
-			// This error can happen if ref was updated at the same time as commit was pushed.
-			return strings.Contains(err.Error(), fmt.Sprintf("Could not update refs/heads/%s. Please refresh and try again..", *opts.Branch))
-		}),
-		retry.Delay(1*time.Second),
-		retry.MaxDelay(3*time.Second),
+		append(policy.options(ctx),
+			retry.RetryIf(func(err error) bool {
+				// This error can happen if ref was updated at the same time as commit was pushed.
+				return notCreated || strings.Contains(err.Error(), fmt.Sprintf("Could not update refs/heads/%s. Please refresh and try again..", *opts.Branch))
+			}),
+		)...,
 	)
+
+	return commit, err
+}
+
+// createdCommit returns whether the head of the branch is the commit of opts created on parent
+func createdCommit(head *gitlab.Commit, parent string, opts *gitlab.CreateCommitOptions) bool {
+	return head != nil && opts.CommitMessage != nil &&
+		len(head.ParentIDs) == 1 && head.ParentIDs[0] == parent &&
+		strings.TrimSpace(head.Message) == strings.TrimSpace(*opts.CommitMessage)
 }
 
 func logD(v string) {
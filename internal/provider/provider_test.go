@@ -2,57 +2,179 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"github.com/stretchr/testify/assert"
 	"github.com/xanzy/go-gitlab"
-	"log"
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
-var testAccProvider *schema.Provider
 var testAccProviderFactories = map[string]func() (*schema.Provider, error){
 	"gitlabcommit": func() (*schema.Provider, error) {
 		return New(), nil
 	},
 }
 
-func init() {
-	testAccProvider = New()
-	testAccProvider.Configure(context.Background(), &terraform.ResourceConfig{})
-	testAccProviderFactories = map[string]func() (*schema.Provider, error){
-		"gitlabcommit": func() (*schema.Provider, error) {
-			return testAccProvider, nil
-		},
-	}
-}
-
 func TestProvider(t *testing.T) {
 	if err := New().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
 
-func testAccPreCheck(t *testing.T) {
-	if v := os.Getenv("GITLAB_TOKEN"); v == "" {
-		t.Fatalf("GITLAB_TOKEN env var must be set for acceptance test")
+func TestProviderDebounceTime(t *testing.T) {
+	// the syncronizer ticks every half debounce time, which must not be zero
+	for debounce, valid := range map[int]bool{0: false, 1: true, 200: true} {
+		diags := New().Validate(terraform.NewResourceConfigRaw(map[string]interface{}{"debounce_time": debounce}))
+		assert.Equal(t, !valid, diags.HasError(), "debounce_time = %d: %+v", debounce, diags)
+	}
+}
+
+// testAccProviderConfig configures the provider against the fake GitLab, the acceptance tests do not need a GitLab instance
+func testAccProviderConfig(server *gitlabfake.Server) string {
+	return fmt.Sprintf(`
+provider "gitlabcommit" {
+  gitlab_api_token = "token"
+  project_id       = "1"
+  branch           = "%s"
+  base_url         = "%s"
+}
+`, gitlabfake.DefaultBranch, server.URL)
+}
+
+// testProvider configures the provider against the fake GitLab without Terraform, the attributes are added to the provider configuration
+func testProvider(t *testing.T, server *gitlabfake.Server, attributes map[string]interface{}) (*schema.Provider, *client) {
+	config := map[string]interface{}{
+		"gitlab_api_token": "token",
+		"project_id":       "1",
+		"branch":           gitlabfake.DefaultBranch,
+		"base_url":         server.URL,
 	}
-	if v := os.Getenv("PROJECT_ID"); v == "" {
-		t.Fatalf("PROJECT_ID env var must be for acceptance test")
+	for key, value := range attributes {
+		config[key] = value
 	}
+
+	p := New()
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(config))
+	if diags.HasError() {
+		t.Fatalf("unable to configure provider: %+v", diags)
+	}
+	return p, p.Meta().(*client)
+}
+
+// applyResource plans and applies the configuration of the resource like Terraform, a nil config destroys it
+func applyResource(p *schema.Provider, resourceType string, state *terraform.InstanceState, config map[string]interface{}) (*terraform.InstanceState, error) {
+	r := p.ResourcesMap[resourceType]
+	ctx := context.Background()
+
+	var (
+		diff *terraform.InstanceDiff
+		err  error
+	)
+	if config == nil {
+		diff = &terraform.InstanceDiff{Destroy: true}
+	} else if diff, err = r.Diff(ctx, state, terraform.NewResourceConfigRaw(config), p.Meta()); err != nil {
+		return nil, err
+	}
+	if diff == nil {
+		return state, nil
+	}
+
+	state, diags := r.Apply(ctx, state, diff, p.Meta())
+	if diags.HasError() {
+		return state, fmt.Errorf("%+v", diags)
+	}
+	return state, nil
+}
+
+func TestProviderFakeGitLab(t *testing.T) {
+	var (
+		server        = gitlabfake.NewServer()
+		numberOfFiles = 20
+		states        = make([]*terraform.InstanceState, numberOfFiles)
+	)
+	defer server.Close()
+
+	p, c := testProvider(t, server, map[string]interface{}{
+		"expected_changes": numberOfFiles,
+		// a create waiting for the rate limit before checking whether its file exists must not miss the commit
+		"debounce_time": 1500,
+		"retry": []interface{}{map[string]interface{}{
+			"min_backoff":            10,
+			"max_backoff":            100,
+			"jitter":                 0,
+			"retryable_status_codes": []interface{}{409, 429, 500},
+		}},
+	})
+
+	// every file is applied at the same time like Terraform does with -parallelism
+	apply := func(content func(i int) map[string]interface{}) {
+		wg := sync.WaitGroup{}
+		wg.Add(numberOfFiles)
+		for i := 0; i < numberOfFiles; i++ {
+			go func(i int) {
+				defer wg.Done()
+				state, err := applyResource(p, "gitlabcommit_file", states[i], content(i))
+				assert.NoError(t, err)
+				states[i] = state
+			}(i)
+		}
+		wg.Wait()
+	}
+	config := func(content string) func(i int) map[string]interface{} {
+		return func(i int) map[string]interface{} {
+			return map[string]interface{}{"file_path": fmt.Sprintf("dir/file-%d.txt", i), "content": fmt.Sprintf("%s %d", content, i)}
+		}
+	}
+
+	server.Fail(gitlabfake.RefRace(gitlabfake.DefaultBranch, 2))
+	server.Fail(gitlabfake.RateLimited(1, 1))
+	server.Fail(gitlabfake.ServerError(http.MethodGet, "", 3))
+	apply(config("created"))
+
+	assert.Len(t, server.Files("1", gitlabfake.DefaultBranch), numberOfFiles)
+	assert.Len(t, server.Commits("1", gitlabfake.DefaultBranch), 2, "the files should be created in one commit")
+	assert.GreaterOrEqual(t, server.Requests(http.MethodPost, "/repository/commits"), 3, "the ref races should be retried")
+	head, _ := server.Branch("1", gitlabfake.DefaultBranch)
+	for i, state := range states {
+		assert.Equal(t, fmt.Sprintf("dir/file-%d.txt", i), state.ID)
+		assert.Equal(t, head, state.Attributes["commit_id"])
+		assert.Equal(t, fmt.Sprintf("created %d", i), state.Attributes["content"])
+	}
+
+	// the state is read again like a refresh, with a file changed outside of Terraform
+	server.WriteFile("1", gitlabfake.DefaultBranch, "dir/file-0.txt", []byte("changed outside"))
+	c.reads.invalidate(commitLocation{projectId: "1", branch: gitlabfake.DefaultBranch})
+	state, diags := p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), states[0], c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	assert.Equal(t, "changed outside", state.Attributes["content"])
+	assert.Equal(t, "true", state.Attributes["changed_outside"])
+	states[0] = state
+
+	apply(config("updated"))
+	content, _ := server.File("1", gitlabfake.DefaultBranch, "dir/file-0.txt")
+	assert.Equal(t, "updated 0", string(content))
+	assert.Len(t, server.Commits("1", gitlabfake.DefaultBranch), 4)
+
+	apply(func(i int) map[string]interface{} { return nil })
+	assert.Empty(t, server.Files("1", gitlabfake.DefaultBranch))
+	assert.Len(t, server.Commits("1", gitlabfake.DefaultBranch), 5)
 }
 
 func TestActionSyncronizer(t *testing.T) {
 	var (
-		inputActions   []*gitlab.CommitActionOptions
-		debounce       = 50 * time.Millisecond
-		actionCh       = make(chan *gitlab.CommitActionOptions)
-		responseSyncCh = make(chan *responseSync)
-		wg             = sync.WaitGroup{}
+		inputActions []*gitlab.CommitActionOptions
+		debounce     = 50 * time.Millisecond
+		actionCh     = make(chan *commitRequest)
+		wg           = sync.WaitGroup{}
 	)
 
 	for i := 0; i < 100; i++ {
@@ -62,29 +184,551 @@ func TestActionSyncronizer(t *testing.T) {
 		})
 	}
 
-	doCommits := func(actualActions []*gitlab.CommitActionOptions) error {
+	commit := &gitlab.Commit{ID: "6104942438c14ec7bd21c6cd5bd995272b3faff6"}
+	doCommits := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		actualActions := batch.actions()
 		assert.Equal(t, inputActions, actualActions)
 		wg.Done()
-		return nil
+		return commit, nil, nil
 	}
 
 	start := time.Now()
 	wg.Add(1)
 	go func() {
-		actionSyncronizer(debounce, actionCh, responseSyncCh, doCommits)
+		actionSyncronizer(context.Background(), debounce, 0, commitLimits{}, actionCh, doCommits)
 	}()
 
-	for i, action := range inputActions {
-		actionCh <- action
-
-		if i != 0 {
-			resp := <-responseSyncCh
-			assert.Equal(t, *inputActions[i].FilePath, resp.filePath)
-			assert.NoError(t, resp.err)
-		}
+	var requests []*commitRequest
+	for _, action := range inputActions {
+		request := &commitRequest{id: *action.FilePath, reply: make(chan *responseSync, 1), actions: []*gitlab.CommitActionOptions{action}}
+		requests = append(requests, request)
+		actionCh <- request
 	}
-	log.Println("here 1")
 	wg.Wait()
 	within100Milli := time.Now().Add(time.Millisecond * -100)
 	assert.WithinDuration(t, within100Milli, start, 50*time.Millisecond)
+
+	// no resource is acknowledged before the commit is done, then every resource is acknowledged
+	for _, request := range requests {
+		resp := <-request.reply
+		assert.Equal(t, commit, resp.commit)
+		assert.NoError(t, resp.err)
+	}
+}
+
+func TestActionSyncronizerExpectedChanges(t *testing.T) {
+	tests := []struct {
+		name            string
+		expected        int
+		debounce        time.Duration
+		actions         int
+		expectedCommits []int
+	}{
+		{
+			name:            "commits when all expected actions are received",
+			expected:        5,
+			debounce:        time.Hour,
+			actions:         5,
+			expectedCommits: []int{5},
+		},
+		{
+			name:            "commits the actions above expected after debounce",
+			expected:        3,
+			debounce:        50 * time.Millisecond,
+			actions:         5,
+			expectedCommits: []int{3, 2},
+		},
+		{
+			name:            "falls back to debounce when fewer actions than expected are received",
+			expected:        5,
+			debounce:        50 * time.Millisecond,
+			actions:         3,
+			expectedCommits: []int{3},
+		},
+		{
+			name:            "commits the remaining expected actions after a debounced commit",
+			expected:        5,
+			debounce:        50 * time.Millisecond,
+			actions:         5,
+			expectedCommits: []int{3, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				actionCh = make(chan *commitRequest)
+				commits  = make(chan int)
+			)
+
+			doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+				commits <- len(batch.actions())
+				return &gitlab.Commit{}, nil, nil
+			}
+			go actionSyncronizer(context.Background(), tt.debounce, tt.expected, commitLimits{}, actionCh, doCommit)
+
+			var sent int
+			for _, batchSize := range tt.expectedCommits {
+				var requests []*commitRequest
+				for i := 0; i < batchSize && sent < tt.actions; i++ {
+					filePath := fmt.Sprintf("path/text-%d.txt", sent)
+					request := &commitRequest{
+						id:    filePath,
+						reply: make(chan *responseSync, 1),
+						actions: []*gitlab.CommitActionOptions{{
+							Action:   gitlab.FileAction(gitlab.FileCreate),
+							FilePath: gitlab.String(filePath),
+						}},
+					}
+					requests = append(requests, request)
+					actionCh <- request
+					sent++
+				}
+
+				assert.Equal(t, batchSize, <-commits)
+				for _, request := range requests {
+					assert.NoError(t, (<-request.reply).err)
+				}
+			}
+		})
+	}
+}
+
+func TestActionSyncronizerLocations(t *testing.T) {
+	var (
+		actionCh  = make(chan *commitRequest)
+		mu        sync.Mutex
+		committed = map[commitLocation][]string{}
+	)
+
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, action := range batch.actions() {
+			committed[batch.location] = append(committed[batch.location], *action.FilePath)
+		}
+		return &gitlab.Commit{ID: batch.location.projectId + "/" + batch.location.branch}, nil, nil
+	}
+	go actionSyncronizer(context.Background(), time.Hour, 4, commitLimits{}, actionCh, doCommit)
+
+	var (
+		main    = commitLocation{projectId: "1", branch: "main"}
+		develop = commitLocation{projectId: "1", branch: "develop"}
+		other   = commitLocation{projectId: "2", branch: "main"}
+	)
+	requests := []*commitRequest{
+		{id: "1:main:a.txt", location: main, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("a.txt")}}},
+		{id: "1:develop:a.txt", location: develop, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("a.txt")}}},
+		{id: "2:main:b.txt", location: other, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("b.txt")}}},
+		{id: "1:main:c.txt", location: main, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String("c.txt")}}},
+	}
+	for _, request := range requests {
+		request.reply = make(chan *responseSync, 1)
+		actionCh <- request
+	}
+
+	// every resource receives the commit of its own location
+	commitIDs := map[string]string{}
+	for _, request := range requests {
+		resp := <-request.reply
+		assert.NoError(t, resp.err)
+		commitIDs[request.id] = resp.commit.ID
+	}
+	assert.Equal(t, map[string]string{
+		"1:main:a.txt":    "1/main",
+		"1:develop:a.txt": "1/develop",
+		"2:main:b.txt":    "2/main",
+		"1:main:c.txt":    "1/main",
+	}, commitIDs)
+
+	assert.Equal(t, map[commitLocation][]string{
+		main:    {"a.txt", "c.txt"},
+		develop: {"a.txt"},
+		other:   {"b.txt"},
+	}, committed)
+}
+
+func TestConfigureBaseURL(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		requestPaths []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestPaths = append(requestPaths, r.URL.EscapedPath())
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v4/version":
+			fmt.Fprint(w, `{"version":"14.3.0","revision":"abc"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/1/repository/commits":
+			fmt.Fprint(w, `{"id":"6104942438c14ec7bd21c6cd5bd995272b3faff6"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/1/repository/files/dir/file.txt":
+			fmt.Fprint(w, `{"file_path":"dir/file.txt","content":"Y29udGVudA=="}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+		"gitlab_api_token": "token",
+		"project_id":       "1",
+		"base_url":         server.URL,
+	})
+
+	meta, diags := configure(context.Background(), d)
+	assert.False(t, diags.HasError())
+	c := meta.(*client)
+
+	commit, err := sendCommitActions(context.Background(), c.retry, c.projectId, c.repository, nil, &gitlab.CreateCommitOptions{
+		Branch: gitlab.String(c.branch),
+		Actions: []*gitlab.CommitActionOptions{{
+			Action:   gitlab.FileAction(gitlab.FileCreate),
+			FilePath: gitlab.String("dir/file.txt"),
+			Content:  gitlab.String("content"),
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "6104942438c14ec7bd21c6cd5bd995272b3faff6", commit.ID)
+
+	file, err := getFile(context.Background(), "dir/file.txt", c.branch, c.projectId, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/file.txt", file.FilePath)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, requestPaths, "/api/v4/version")
+	assert.Contains(t, requestPaths, "/api/v4/projects/1/repository/commits")
+	assert.Contains(t, requestPaths, "/api/v4/projects/1/repository/files/dir%2Ffile%2Etxt")
+}
+
+func TestConfigureBaseURLUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+		"gitlab_api_token": "token",
+		"project_id":       "1",
+		"base_url":         server.URL,
+	})
+
+	_, diags := configure(context.Background(), d)
+	assert.True(t, diags.HasError())
+}
+
+func TestSendCommitActionsFailedAttempt(t *testing.T) {
+	tests := []struct {
+		name            string
+		failure         gitlabfake.Failure
+		expectedPosts   int
+		expectedCommits int
+	}{
+		{
+			name:            "commit created by the failed attempt is not sent again",
+			failure:         gitlabfake.LostResponse(http.MethodPost, "/repository/commits", 1),
+			expectedPosts:   1,
+			expectedCommits: 2,
+		},
+		{
+			name:            "commit not created by the failed attempt is sent again",
+			failure:         gitlabfake.Failure{Method: http.MethodPost, PathSuffix: "/repository/commits", Status: http.StatusServiceUnavailable, Times: 1},
+			expectedPosts:   2,
+			expectedCommits: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gitlabfake.NewServer()
+			defer server.Close()
+			_, c := testProvider(t, server, map[string]interface{}{
+				"retry": []interface{}{map[string]interface{}{
+					"min_backoff": 10,
+					"max_backoff": 100,
+					"jitter":      0,
+				}},
+			})
+			defer c.close()
+
+			// the branch exists before the commit
+			server.Commits("1", gitlabfake.DefaultBranch)
+			server.Fail(tt.failure)
+			commit, err := sendCommitActions(context.Background(), c.retry, c.projectId, c.repository, nil, &gitlab.CreateCommitOptions{
+				Branch:        gitlab.String(c.branch),
+				CommitMessage: gitlab.String("Create dir/file.txt"),
+				Actions: []*gitlab.CommitActionOptions{{
+					Action:   gitlab.FileAction(gitlab.FileCreate),
+					FilePath: gitlab.String("dir/file.txt"),
+					Content:  gitlab.String("content"),
+				}},
+			})
+			assert.NoError(t, err)
+
+			head, _ := server.Branch("1", gitlabfake.DefaultBranch)
+			assert.Equal(t, head, commit.ID)
+			assert.Equal(t, tt.expectedPosts, server.Requests(http.MethodPost, "/repository/commits"))
+			assert.Len(t, server.Commits("1", gitlabfake.DefaultBranch), tt.expectedCommits)
+		})
+	}
+}
+
+func TestActionSyncronizerSplitsCommits(t *testing.T) {
+	var (
+		actionCh = make(chan *commitRequest)
+		mu       sync.Mutex
+		parents  []string
+	)
+
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		parents = append(parents, batch.parent)
+		if batch.part == 2 {
+			return nil, nil, errors.New("request entity too large")
+		}
+		return &gitlab.Commit{ID: fmt.Sprintf("commit-%d", batch.part)}, nil, nil
+	}
+	go actionSyncronizer(context.Background(), time.Hour, 5, commitLimits{maxActions: 2}, actionCh, doCommit)
+
+	var requests []*commitRequest
+	for i := 0; i < 5; i++ {
+		request := &commitRequest{
+			id:      fmt.Sprintf("file-%d", i),
+			reply:   make(chan *responseSync, 1),
+			actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String(fmt.Sprintf("file-%d", i))}},
+		}
+		requests = append(requests, request)
+		actionCh <- request
+	}
+
+	responses := map[string]*responseSync{}
+	for _, request := range requests {
+		responses[request.id] = <-request.reply
+	}
+
+	// the first commit is created, the second fails and the third is not sent
+	for _, id := range []string{"file-0", "file-1"} {
+		assert.NoError(t, responses[id].err)
+		assert.Equal(t, "commit-1", responses[id].commit.ID)
+	}
+	for _, id := range []string{"file-2", "file-3"} {
+		assert.EqualError(t, responses[id].err, "commit 2 of 3 failed, the earlier commits were created: request entity too large")
+		assert.Nil(t, responses[id].commit)
+	}
+	assert.EqualError(t, responses["file-4"].err, "not committed since commit 2 of 3 failed: commit 2 of 3 failed, the earlier commits were created: request entity too large")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"", "commit-1"}, parents)
+}
+
+// interleavingCommitter calls after once the first commit has been created, like a push by someone else in between the commits of a split batch
+type interleavingCommitter struct {
+	Committer
+
+	after func()
+
+	once sync.Once
+}
+
+func (c *interleavingCommitter) CreateCommit(ctx context.Context, projectId string, opts *gitlab.CreateCommitOptions) (*gitlab.Commit, error) {
+	commit, err := c.Committer.CreateCommit(ctx, projectId, opts)
+	if err == nil && c.after != nil {
+		c.once.Do(c.after)
+	}
+	return commit, err
+}
+
+func TestHandleResourcesSplitsCommits(t *testing.T) {
+	tests := []struct {
+		name            string
+		pushedBetween   bool
+		expectedFiles   int
+		expectedCommits int
+		expectedErrs    int
+	}{
+		{name: "commits every part on the head of the branch", expectedFiles: 5, expectedCommits: 4},
+		{name: "fails the batch when the branch changed in between", pushedBetween: true, expectedFiles: 2, expectedCommits: 3, expectedErrs: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gitlabfake.NewServer()
+			defer server.Close()
+			gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+			assert.NoError(t, err)
+
+			repository := &interleavingCommitter{Committer: newGitlabCommitter(gitlabClient)}
+			if tt.pushedBetween {
+				repository.after = func() { server.WriteFile("1", gitlabfake.DefaultBranch, "other.txt", []byte("other")) }
+			}
+			d := schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+				"commit_message":         "Update files",
+				"expected_changes":       5,
+				"max_actions_per_commit": 2,
+			})
+			var (
+				ctx, cancel = context.WithCancel(context.Background())
+				actionCh    = make(chan *commitRequest)
+				c           = &client{actionCh: actionCh, done: handleResources(ctx, d, gitlabClient, repository, nil, newReadCache(repository), actionCh)}
+				mu          sync.Mutex
+				errs        int
+				wg          sync.WaitGroup
+			)
+			defer cancel()
+
+			wg.Add(5)
+			for i := 0; i < 5; i++ {
+				go func(i int) {
+					defer wg.Done()
+					filePath := fmt.Sprintf("file-%d.txt", i)
+					_, err := sendRequest(context.Background(), c, &commitRequest{
+						id:       filePath,
+						location: commitLocation{projectId: "1", branch: gitlabfake.DefaultBranch},
+						actions:  []*gitlab.CommitActionOptions{{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String(filePath), Content: gitlab.String("content")}},
+					})
+					if err != nil {
+						mu.Lock()
+						errs++
+						mu.Unlock()
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			assert.Equal(t, tt.expectedErrs, errs)
+			var files int
+			for _, filePath := range server.Files("1", gitlabfake.DefaultBranch) {
+				if strings.HasPrefix(filePath, "file-") {
+					files++
+				}
+			}
+			assert.Equal(t, tt.expectedFiles, files)
+			// the initial commit of the project is included
+			assert.Len(t, server.Commits("1", gitlabfake.DefaultBranch), tt.expectedCommits)
+		})
+	}
+}
+
+func TestActionSyncronizerStop(t *testing.T) {
+	defer checkGoroutines(t)()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		actionCh    = make(chan *commitRequest)
+		done        = make(chan struct{})
+		started     = make(chan struct{})
+		release     = make(chan struct{})
+		commits     int
+		c           = &client{actionCh: actionCh, done: done}
+	)
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		commits++
+		close(started)
+		<-release
+		return &gitlab.Commit{ID: "abc"}, nil, nil
+	}
+	go func() {
+		defer close(done)
+		actionSyncronizer(ctx, time.Hour, 1, commitLimits{}, actionCh, doCommit)
+	}()
+
+	send := func(id string) <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			resp, err := sendRequest(context.Background(), c, &commitRequest{id: id, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String(id)}}})
+			if err == nil && resp.commit.ID != "abc" {
+				err = fmt.Errorf("unexpected commit %s", resp.commit.ID)
+			}
+			errCh <- err
+		}()
+		return errCh
+	}
+
+	// the commit in progress is finished when the provider is stopped, the pending request is rejected
+	inProgress := send("a.txt")
+	<-started
+	pending := send("b.txt")
+	cancel()
+	close(release)
+
+	assert.NoError(t, <-inProgress)
+	assert.ErrorIs(t, <-pending, errProviderStopped)
+	<-done
+	assert.ErrorIs(t, <-send("c.txt"), errProviderStopped, "requests after the stop should be rejected")
+	assert.Equal(t, 1, commits)
+}
+
+func TestConfigureStopsPreviousSyncronizer(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	defer checkGoroutines(t)()
+
+	p := New()
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"gitlab_api_token": "token",
+		"project_id":       "1",
+		"base_url":         server.URL,
+	})
+	assert.False(t, p.Configure(context.Background(), config).HasError())
+	first := p.Meta().(*client)
+	assert.False(t, p.Configure(context.Background(), config).HasError())
+	second := p.Meta().(*client)
+
+	_, err := sendRequest(context.Background(), first, &commitRequest{id: "a.txt"})
+	assert.ErrorIs(t, err, errProviderStopped, "the previous configuration should be stopped")
+
+	second.close()
+	_, err = sendRequest(context.Background(), second, &commitRequest{id: "a.txt"})
+	assert.ErrorIs(t, err, errProviderStopped)
+}
+
+// checkGoroutines works like goleak for the provider package, the returned function fails the test if goroutines started after calling checkGoroutines are still running
+func checkGoroutines(t *testing.T) func() {
+	before := providerGoroutines()
+	return func() {
+		t.Helper()
+		var leaked []string
+		// the goroutines need a moment to exit after being stopped
+		for i := 0; i < 100; i++ {
+			leaked = nil
+			for id, stack := range providerGoroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("found %d leaked goroutines:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+	}
+}
+
+// providerGoroutines returns the stacks of the goroutines running code of the provider by goroutine ID, the goroutines of the tests are left out
+func providerGoroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	goroutines := map[string]string{}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// every stack starts with "goroutine <id> [<state>]:"
+		fields := strings.Fields(stack)
+		if len(fields) < 2 || !strings.Contains(stack, "/internal/provider.") || strings.Contains(stack, "testing.tRunner") {
+			continue
+		}
+		goroutines[fields[1]] = stack
+	}
+	return goroutines
 }
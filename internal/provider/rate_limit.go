@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitTransport limits the requests to GitLab per second and the requests sent at the same time.
+// The rate is lowered to spread the requests remaining according to the RateLimit headers of GitLab until the limit is reset.
+type rateLimitTransport struct {
+	next http.RoundTripper
+
+	limiter *rate.Limiter
+
+	// base is the configured rate, the limiter is set back to it when GitLab allows more requests
+	base rate.Limit
+
+	// slots limits the concurrent requests, it is nil when unlimited
+	slots chan struct{}
+
+	// mu serializes adapting the limiter to the responses
+	mu sync.Mutex
+}
+
+// newRateLimitTransport limits the requests sent by next, a limit of zero is unlimited
+func newRateLimitTransport(next http.RoundTripper, requestsPerSecond float64, concurrentRequests int) *rateLimitTransport {
+	base, burst := rate.Inf, 1
+	if requestsPerSecond > 0 {
+		base, burst = rate.Limit(requestsPerSecond), int(math.Ceil(requestsPerSecond))
+	}
+
+	t := &rateLimitTransport{
+		next:    next,
+		limiter: rate.NewLimiter(base, burst),
+		base:    base,
+	}
+	if concurrentRequests > 0 {
+		t.slots = make(chan struct{}, concurrentRequests)
+	}
+	return t
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+			defer func() { <-t.slots }()
+		case <-ctx.Done():
+			closeBody(req)
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := t.limiter.Wait(ctx); err != nil {
+		closeBody(req)
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.adapt(resp)
+	}
+	return resp, err
+}
+
+// adapt sets the rate to the remaining requests divided by the time until GitLab resets the limit, if it is lower than the configured rate
+func (t *rateLimitTransport) adapt(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	window := time.Until(time.Unix(reset, 0))
+	if window <= 0 {
+		t.limiter.SetLimit(t.base)
+		return
+	}
+
+	// a request is still allowed when none remain, GitLab answers it with a Retry-After
+	if remaining < 1 {
+		remaining = 1
+	}
+	limit := rate.Limit(float64(remaining) / window.Seconds())
+	if limit >= t.base {
+		t.limiter.SetLimit(t.base)
+		return
+	}
+	if t.limiter.Limit() == t.base {
+		logD(fmt.Sprintf("[PROVIDER] %d requests remaining for %s, limiting to %.2f requests per second", remaining, window.Round(time.Second), float64(limit)))
+	}
+	t.limiter.SetLimit(limit)
+}
+
+// closeBody closes the body of a request which is not sent, as required from a http.RoundTripper
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitTransportRequestsPerSecond(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: newRateLimitTransport(http.DefaultTransport, 10, 0)}
+
+	// the first 10 requests use the burst, the next 5 have to wait for the rate
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		resp, err := httpClient.Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestRateLimitTransportConcurrentRequests(t *testing.T) {
+	var (
+		mu              sync.Mutex
+		inFlight, maxIn int
+		wg              sync.WaitGroup
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxIn {
+			maxIn = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: newRateLimitTransport(http.DefaultTransport, 0, 2)}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := httpClient.Get(server.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, maxIn)
+}
+
+func TestRateLimitTransportAdapts(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		reset time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("RateLimit-Remaining", "10")
+		w.Header().Set("RateLimit-Reset", fmt.Sprint(reset.Unix()))
+	}))
+	defer server.Close()
+
+	transport := newRateLimitTransport(http.DefaultTransport, 5, 0)
+	httpClient := &http.Client{Transport: transport}
+	get := func(resetIn time.Duration) {
+		mu.Lock()
+		reset = time.Now().Add(resetIn)
+		mu.Unlock()
+		resp, err := httpClient.Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// 10 remaining requests in 100 seconds
+	get(100 * time.Second)
+	assert.InDelta(t, 0.1, float64(transport.limiter.Limit()), 0.01)
+
+	// 10 remaining requests in 1 second allows more than the configured rate
+	get(time.Second + 500*time.Millisecond)
+	assert.Equal(t, rate.Limit(5), transport.limiter.Limit())
+}
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// readCache lists the repository tree of the files being read, so a file is only fetched if its blob ID has changed.
+// The tree is listed once per location until a commit to the location invalidates it.
+type readCache struct {
+	repository Committer
+
+	mu sync.Mutex
+
+	trees map[commitLocation]*cachedTree
+}
+
+// cachedTree is the listing of the tree of a location, ready is closed when blobs and err are set
+type cachedTree struct {
+	ready chan struct{}
+
+	// blobs are the blob IDs of the files in the tree by path
+	blobs map[string]string
+
+	err error
+}
+
+func newReadCache(repository Committer) *readCache {
+	return &readCache{repository: repository, trees: map[commitLocation]*cachedTree{}}
+}
+
+// unchanged returns whether the file in the repository still has the blob ID, the tree of the location is listed if it is not cached.
+// The reads are not cached without a readCache.
+func (r *readCache) unchanged(ctx context.Context, location commitLocation, filePath, blobID string) (bool, error) {
+	if r == nil || blobID == "" {
+		return false, nil
+	}
+
+	blobs, err := r.tree(ctx, location)
+	if err != nil {
+		return false, err
+	}
+	return blobs[filePath] == blobID, nil
+}
+
+// tree returns the blob IDs of the files of the location, only the first caller lists it while the others wait for the result
+func (r *readCache) tree(ctx context.Context, location commitLocation) (map[string]string, error) {
+	r.mu.Lock()
+	cached, ok := r.trees[location]
+	if !ok {
+		cached = &cachedTree{ready: make(chan struct{})}
+		r.trees[location] = cached
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		cached.blobs, cached.err = r.list(ctx, location)
+		if cached.err != nil {
+			// the next read lists the tree again instead of getting the error
+			r.mu.Lock()
+			if r.trees[location] == cached {
+				delete(r.trees, location)
+			}
+			r.mu.Unlock()
+		}
+		close(cached.ready)
+	}
+
+	select {
+	case <-cached.ready:
+		return cached.blobs, cached.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// list returns the blob IDs of every file of the location
+func (r *readCache) list(ctx context.Context, location commitLocation) (map[string]string, error) {
+	logD(fmt.Sprintf("[PROVIDER] listing %s on %s for reading", location.projectId, location.branch))
+
+	blobs := map[string]string{}
+	nodes, err := r.repository.ListTree(ctx, location.projectId, "", location.branch, true)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// the files are read one by one if the branch does not exist
+			return blobs, nil
+		}
+		return nil, fmt.Errorf("unable to list %s on %s: %w", location.projectId, location.branch, err)
+	}
+
+	for _, node := range nodes {
+		if node.Type == "blob" {
+			blobs[node.Path] = node.ID
+		}
+	}
+	return blobs, nil
+}
+
+// invalidate removes the tree of the location, so the files committed to it are read again
+func (r *readCache) invalidate(location commitLocation) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.trees, location)
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestReadCache(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		listings = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/tree":
+			mu.Lock()
+			listings[r.URL.Query().Get("path")]++
+			mu.Unlock()
+
+			assert.Equal(t, "main", r.URL.Query().Get("ref"))
+			assert.Equal(t, "true", r.URL.Query().Get("recursive"))
+			fmt.Fprint(w, `[{"id":"a1","type":"blob","path":"dir/a.txt"},{"id":"b1","type":"blob","path":"dir/b.txt"},{"id":"t1","type":"tree","path":"dir/sub"},{"id":"c1","type":"blob","path":"c.txt"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	reads := newReadCache(newGitlabCommitter(gitlabClient))
+	main := commitLocation{projectId: "1", branch: "main"}
+
+	tests := []struct {
+		filePath string
+		blobID   string
+		expected bool
+	}{
+		{filePath: "dir/a.txt", blobID: "a1", expected: true},
+		{filePath: "dir/b.txt", blobID: "a1", expected: false},
+		{filePath: "dir/new.txt", blobID: "a1", expected: false},
+		{filePath: "dir/b.txt", expected: false},
+		{filePath: "c.txt", blobID: "c1", expected: true},
+		{filePath: "missing/d.txt", blobID: "d1", expected: false},
+	}
+
+	// the files are read in parallel like the resources
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		tt := tt
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unchanged, err := reads.unchanged(context.Background(), main, tt.filePath, tt.blobID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, unchanged, tt.filePath)
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	assert.Equal(t, map[string]int{"": 1}, listings, "the tree should be listed once for every file")
+	mu.Unlock()
+
+	// a commit to the location makes the tree be listed again
+	reads.invalidate(main)
+	unchanged, err := reads.unchanged(context.Background(), main, "dir/a.txt", "a1")
+	assert.NoError(t, err)
+	assert.True(t, unchanged)
+
+	mu.Lock()
+	assert.Equal(t, 2, listings[""])
+	mu.Unlock()
+}
+
+func TestReadCacheNil(t *testing.T) {
+	var reads *readCache
+	unchanged, err := reads.unchanged(context.Background(), commitLocation{}, "a.txt", "a1")
+	assert.NoError(t, err)
+	assert.False(t, unchanged)
+	reads.invalidate(commitLocation{})
+}
@@ -0,0 +1,442 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/xanzy/go-gitlab"
+)
+
+func resourceGitlabCommitDirectory() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "The directory resource will mirror a local directory into a directory of the repository based on the provided Gitlab project ID. " +
+			"Files are created, updated and deleted in one commit so the repository directory matches the local directory exactly.",
+
+		CreateContext: resourceGitlabcommitDirectoryCreate,
+		ReadContext:   resourceGitlabcommitDirectoryRead,
+		UpdateContext: resourceGitlabcommitDirectoryUpdate,
+		DeleteContext: resourceGitlabcommitDirectoryDelete,
+		CustomizeDiff: resourceGitlabcommitDirectoryCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"source_dir": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Path to the local directory.",
+			},
+			"target_dir": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The directory in the repository, every file in it not matching a local file is deleted unless it matches `exclude`.",
+			},
+			"include": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Glob patterns, relative to `source_dir`, of the files to include. `**` matches any number of directories. Defaults to all files.",
+			},
+			"exclude": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Glob patterns, relative to `source_dir`, of the files to exclude. `**` matches any number of directories. The files in `target_dir` matching them are not managed: they are neither tracked in `files` nor deleted.",
+			},
+			"project_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The project of the directory. Defaults to the provider `project_id`.",
+			},
+			"branch": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The branch of the directory. Defaults to the provider `branch`.",
+			},
+			"files": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The files in the directory, the key is the path relative to `target_dir` and the value is the git blob ID (SHA-1) of the content.",
+			},
+			"commit_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA of the commit that last changed the directory through this resource.",
+			},
+			"commit_url": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The web URL of the commit given by `commit_id`.",
+			},
+			"merge_request_iid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The IID of the merge request containing the commit given by `commit_id`, when using the provider `merge_request` block.",
+			},
+			"merge_request_url": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The web URL of the merge request given by `merge_request_iid`.",
+			},
+		},
+	}
+}
+
+func resourceGitlabcommitDirectoryRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	projectId, branch := resourceLocation(d, client)
+	setLocation(d, client)
+	branch, err := mergeRequestBranch(ctx, d, client, projectId, branch)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	files, err := listDirectory(ctx, d.Get("target_dir").(string), branch, projectId, client.repository, toStrings(d.Get("exclude")))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("files", files)
+
+	return nil
+}
+
+func resourceGitlabcommitDirectoryCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := applyDirectory(ctx, meta.(*client), d); diags != nil {
+		return diags
+	}
+
+	d.SetId(d.Get("target_dir").(string))
+	setLocation(d, meta.(*client))
+
+	// the files are not in the repository in a dry run, so the planned state is kept
+	if meta.(*client).dryRun {
+		return nil
+	}
+	return resourceGitlabcommitDirectoryRead(ctx, d, meta)
+}
+
+func resourceGitlabcommitDirectoryUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := applyDirectory(ctx, meta.(*client), d); diags != nil {
+		// the commit failed so the state must keep the files that are still in the repository
+		d.Partial(true)
+		return diags
+	}
+
+	if meta.(*client).dryRun {
+		return nil
+	}
+	return resourceGitlabcommitDirectoryRead(ctx, d, meta)
+}
+
+func resourceGitlabcommitDirectoryDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	targetDir := d.Get("target_dir").(string)
+	projectId, branch := resourceLocation(d, client)
+
+	remote, err := listDirectory(ctx, targetDir, branch, projectId, client.repository, toStrings(d.Get("exclude")))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	actions, err := directoryActions(targetDir, nil, remote)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if len(actions) > 0 {
+		if _, err := sendRequest(ctx, client, directoryRequest(client, projectId, branch, targetDir, actions)); err != nil {
+			return diagFromDirectoryCommitErr(actions, err)
+		}
+	}
+
+	d.SetId("")
+	return nil
+}
+
+// resourceGitlabcommitDirectoryCustomizeDiff sets the planned files from the local directory, which is the only diff when local files change
+func resourceGitlabcommitDirectoryCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range []string{"source_dir", "include", "exclude"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("files")
+		}
+	}
+
+	local, err := readLocalDirectory(d.Get("source_dir").(string), toStrings(d.Get("include")), toStrings(d.Get("exclude")))
+	if err != nil {
+		return err
+	}
+
+	planned := map[string]interface{}{}
+	for filePath, file := range local {
+		planned[filePath] = file.blobID
+	}
+
+	if d.Id() != "" && mapsEqual(d.Get("files").(map[string]interface{}), planned) {
+		return nil
+	}
+	if err := d.SetNew("files", planned); err != nil {
+		return err
+	}
+	if d.Id() != "" {
+		for _, key := range []string{"commit_id", "commit_url", "merge_request_iid", "merge_request_url"} {
+			if err := d.SetNewComputed(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyDirectory commits the actions needed for the repository directory to match the local directory
+func applyDirectory(ctx context.Context, client *client, d *schema.ResourceData) diag.Diagnostics {
+	targetDir := d.Get("target_dir").(string)
+	projectId, branch := resourceLocation(d, client)
+
+	local, err := readLocalDirectory(d.Get("source_dir").(string), toStrings(d.Get("include")), toStrings(d.Get("exclude")))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// the repository is listed again since files might exist before the resource is created
+	remote, err := listDirectory(ctx, targetDir, branch, projectId, client.repository, toStrings(d.Get("exclude")))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	actions, err := directoryActions(targetDir, local, remote)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if len(actions) == 0 {
+		logD("[RESOURCE] directory " + targetDir + " is up to date")
+		return nil
+	}
+
+	logD("[RESOURCE] applying " + strconv.Itoa(len(actions)) + " actions for directory " + targetDir)
+	resp, err := sendRequest(ctx, client, directoryRequest(client, projectId, branch, targetDir, actions))
+	if err != nil {
+		return diagFromDirectoryCommitErr(actions, err)
+	}
+	setCommit(d, resp.commit)
+	setMergeRequest(d, resp.mergeRequest)
+
+	return nil
+}
+
+// directoryRequest creates the request for the actions of the directory, the same directory can be managed in several projects and branches
+func directoryRequest(client *client, projectId, branch, targetDir string, actions []*gitlab.CommitActionOptions) *commitRequest {
+	return &commitRequest{
+		id:       projectId + ":" + branch + ":" + targetDir,
+		location: commitLocation{projectId: projectId, branch: branch},
+		author:   client.author,
+		actions:  actions,
+	}
+}
+
+// localFile is a file found in the source directory
+type localFile struct {
+	// path is the path on disk
+	path string
+
+	blobID string
+}
+
+// readLocalDirectory returns the regular files in dir matching the include and exclude patterns, the key is the slash separated path relative to dir
+func readLocalDirectory(dir string, include, exclude []string) (map[string]*localFile, error) {
+	files := map[string]*localFile{}
+
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !matchesAny(include, rel, true) || matchesAny(exclude, rel, false) {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[rel] = &localFile{path: p, blobID: gitBlobID(content)}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read source_dir: %w", err)
+	}
+
+	return files, nil
+}
+
+// listDirectory returns the blob ID of every file in the repository directory, the key is the path relative to dir.
+// The files matching exclude are left out, so they are neither tracked nor deleted.
+func listDirectory(ctx context.Context, dir, branch, projectId string, repository Committer, exclude []string) (map[string]string, error) {
+	files := map[string]string{}
+
+	nodes, err := repository.ListTree(ctx, projectId, dir, branch, true)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// the directory does not exist until a file is committed to it
+			return files, nil
+		}
+		return nil, fmt.Errorf("unable to list directory %s: %w", dir, err)
+	}
+
+	for _, node := range nodes {
+		if node.Type != "blob" {
+			continue
+		}
+		filePath := strings.TrimPrefix(node.Path, strings.TrimSuffix(dir, "/")+"/")
+		if matchesAny(exclude, filePath, false) {
+			continue
+		}
+		files[filePath] = node.ID
+	}
+	return files, nil
+}
+
+// directoryActions returns the actions making the remote directory match the local directory, sorted by file path
+func directoryActions(targetDir string, local map[string]*localFile, remote map[string]string) ([]*gitlab.CommitActionOptions, error) {
+	var actions []*gitlab.CommitActionOptions
+
+	for filePath, file := range local {
+		blobID, exists := remote[filePath]
+		if exists && blobID == file.blobID {
+			continue
+		}
+
+		// only the content of changed files is read
+		content, err := os.ReadFile(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file.path, err)
+		}
+
+		action := gitlab.FileAction(gitlab.FileCreate)
+		if exists {
+			action = gitlab.FileAction(gitlab.FileUpdate)
+		}
+		actions = append(actions, &gitlab.CommitActionOptions{
+			Action:   action,
+			FilePath: gitlab.String(path.Join(targetDir, filePath)),
+			Content:  gitlab.String(base64.StdEncoding.EncodeToString(content)),
+			Encoding: gitlab.String("base64"),
+		})
+	}
+
+	for filePath := range remote {
+		if _, exists := local[filePath]; !exists {
+			actions = append(actions, &gitlab.CommitActionOptions{
+				Action:   gitlab.FileAction(gitlab.FileDelete),
+				FilePath: gitlab.String(path.Join(targetDir, filePath)),
+			})
+		}
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return *actions[i].FilePath < *actions[j].FilePath
+	})
+
+	return actions, nil
+}
+
+// diagFromDirectoryCommitErr creates diagnostics for the first file of the directory in conflict, or the error if no file is in conflict
+func diagFromDirectoryCommitErr(actions []*gitlab.CommitActionOptions, err error) diag.Diagnostics {
+	var conflict *conflictError
+	if !errors.As(err, &conflict) {
+		return diag.FromErr(err)
+	}
+	for _, action := range actions {
+		if _, changed := conflict.changedBy[*action.FilePath]; changed {
+			return diagFromCommitErr(*action.FilePath, err)
+		}
+	}
+	return diagFromCommitErr("", err)
+}
+
+// gitBlobID returns the ID git gives the content, which is the SHA-1 of the content prefixed with a blob header
+func gitBlobID(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// matchesAny checks if name matches any of the patterns, or returns empty if there are no patterns
+func matchesAny(patterns []string, name string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+	for _, pattern := range patterns {
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches the path segments against the pattern segments, where "**" matches zero or more segments
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func toStrings(v interface{}) []string {
+	var s []string
+	for _, e := range v.([]interface{}) {
+		s = append(s, e.(string))
+	}
+	return s
+}
+
+func mapsEqual(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		expected bool
+	}{
+		{patterns: nil, name: "a.txt", expected: true},
+		{patterns: []string{"*.txt"}, name: "a.txt", expected: true},
+		{patterns: []string{"*.txt"}, name: "dir/a.txt", expected: false},
+		{patterns: []string{"**/*.txt"}, name: "a.txt", expected: true},
+		{patterns: []string{"**/*.txt"}, name: "dir/sub/a.txt", expected: true},
+		{patterns: []string{"dir/**"}, name: "dir/sub/a.yaml", expected: true},
+		{patterns: []string{"dir/**"}, name: "other/a.yaml", expected: false},
+		{patterns: []string{"*.yaml", "*.txt"}, name: "a.txt", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v %s", tt.patterns, tt.name), func(t *testing.T) {
+			assert.Equal(t, tt.expected, matchesAny(tt.patterns, tt.name, true))
+		})
+	}
+}
+
+func TestGitBlobID(t *testing.T) {
+	// echo hello | git hash-object --stdin
+	assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", gitBlobID([]byte("hello\n")))
+}
+
+func TestReadLocalDirectory(t *testing.T) {
+	dir := mustCreateDirectory(t, map[string]string{
+		"a.yaml":          "a",
+		"sub/b.yaml":      "b",
+		"sub/c.txt":       "c",
+		"sub/tmp/d.yaml":  "d",
+		"sub/tmp/e.extra": "e",
+	})
+
+	files, err := readLocalDirectory(dir, []string{"**/*.yaml", "**/*.txt"}, []string{"**/tmp/**"})
+	assert.NoError(t, err)
+
+	var paths []string
+	for filePath, file := range files {
+		paths = append(paths, filePath)
+		assert.Equal(t, filepath.Join(dir, filepath.FromSlash(filePath)), file.path)
+	}
+	assert.ElementsMatch(t, []string{"a.yaml", "sub/b.yaml", "sub/c.txt"}, paths)
+	assert.Equal(t, gitBlobID([]byte("b")), files["sub/b.yaml"].blobID)
+}
+
+func TestDirectoryActions(t *testing.T) {
+	dir := mustCreateDirectory(t, map[string]string{
+		"new.yaml":       "new",
+		"changed.yaml":   "changed",
+		"unchanged.yaml": "unchanged",
+	})
+	local, err := readLocalDirectory(dir, nil, nil)
+	assert.NoError(t, err)
+
+	remote := map[string]string{
+		"changed.yaml":   gitBlobID([]byte("old")),
+		"unchanged.yaml": gitBlobID([]byte("unchanged")),
+		"deleted.yaml":   gitBlobID([]byte("deleted")),
+	}
+
+	actions, err := directoryActions("config", local, remote)
+	assert.NoError(t, err)
+	assert.Equal(t, []*gitlab.CommitActionOptions{
+		{
+			Action:   gitlab.FileAction(gitlab.FileUpdate),
+			FilePath: gitlab.String("config/changed.yaml"),
+			Content:  gitlab.String(base64.StdEncoding.EncodeToString([]byte("changed"))),
+			Encoding: gitlab.String("base64"),
+		},
+		{
+			Action:   gitlab.FileAction(gitlab.FileDelete),
+			FilePath: gitlab.String("config/deleted.yaml"),
+		},
+		{
+			Action:   gitlab.FileAction(gitlab.FileCreate),
+			FilePath: gitlab.String("config/new.yaml"),
+			Content:  gitlab.String(base64.StdEncoding.EncodeToString([]byte("new"))),
+			Encoding: gitlab.String("base64"),
+		},
+	}, actions)
+}
+
+func TestListDirectory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path != "/api/v4/projects/1/repository/tree":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Query().Get("path") == "missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 Tree Not Found"}`)
+		case r.URL.Query().Get("page") == "2":
+			fmt.Fprint(w, `[{"id":"b","type":"blob","path":"config/sub/b.yaml"}]`)
+		default:
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `[{"id":"a","type":"blob","path":"config/a.yaml"},{"id":"sub","type":"tree","path":"config/sub"}]`)
+		}
+	}))
+	defer server.Close()
+
+	c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	files, err := listDirectory(context.Background(), "config", "main", "1", newGitlabCommitter(c), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a.yaml": "a", "sub/b.yaml": "b"}, files)
+
+	files, err = listDirectory(context.Background(), "config", "main", "1", newGitlabCommitter(c), []string{"sub/**"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a.yaml": "a"}, files, "excluded files should not be listed")
+
+	files, err = listDirectory(context.Background(), "missing", "main", "1", newGitlabCommitter(c), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestResourceDirectoryDiff(t *testing.T) {
+	dir := mustCreateDirectory(t, map[string]string{"a.yaml": "a"})
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"source_dir": dir,
+		"target_dir": "config",
+	})
+
+	state := &terraform.InstanceState{
+		ID: "config",
+		Attributes: map[string]string{
+			"id":           "config",
+			"source_dir":   dir,
+			"target_dir":   "config",
+			"files.%":      "1",
+			"files.a.yaml": gitBlobID([]byte("a")),
+			"commit_id":    "abc",
+		},
+	}
+
+	diff, err := resourceGitlabCommitDirectory().Diff(context.Background(), state, config, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, diff, "unchanged directory should not have a diff")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("b"), 0600))
+	diff, err = resourceGitlabCommitDirectory().Diff(context.Background(), state, config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, gitBlobID([]byte("b")), diff.Attributes["files.b.yaml"].New)
+	assert.True(t, diff.Attributes["commit_id"].NewComputed)
+}
+
+// mustCreateDirectory creates a temporary directory with the files, the key is the slash separated path
+func mustCreateDirectory(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for filePath, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResourceDirectoryCreateStoresLocation(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+
+	source := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0600))
+
+	p, _ := testProvider(t, server, nil)
+	state, err := applyResource(p, "gitlabcommit_directory", nil, map[string]interface{}{"source_dir": source, "target_dir": "dir"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1", state.Attributes["project_id"])
+	assert.Equal(t, gitlabfake.DefaultBranch, state.Attributes["branch"])
+	assert.Equal(t, []string{"dir/a.txt"}, server.Files("1", gitlabfake.DefaultBranch))
+}
+
+func TestResourceDirectoryKeepsExcludedFiles(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+
+	// the excluded file is only in the repository, e.g. written by a pipeline
+	server.WriteFile("1", gitlabfake.DefaultBranch, "dir/build.log", []byte("log"))
+	source := mustCreateDirectory(t, map[string]string{"a.txt": "a", "local.log": "local"})
+	config := map[string]interface{}{"source_dir": source, "target_dir": "dir", "exclude": []interface{}{"*.log"}}
+
+	p, c := testProvider(t, server, nil)
+	state, err := applyResource(p, "gitlabcommit_directory", nil, config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"dir/a.txt", "dir/build.log"}, server.Files("1", gitlabfake.DefaultBranch))
+	assert.Equal(t, "1", state.Attributes["files.%"], "excluded files should not be tracked")
+
+	// the excluded file does not cause a diff after a refresh
+	state, diags := p.ResourcesMap["gitlabcommit_directory"].RefreshWithoutUpgrade(context.Background(), state, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	diff, err := p.ResourcesMap["gitlabcommit_directory"].Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), c)
+	assert.NoError(t, err)
+	assert.True(t, diff == nil || diff.Empty(), fmt.Sprintf("%+v", diff))
+
+	assert.NoError(t, os.Remove(filepath.Join(source, "a.txt")))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "b.txt"), []byte("b"), 0600))
+	state, err = applyResource(p, "gitlabcommit_directory", state, config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"dir/b.txt", "dir/build.log"}, server.Files("1", gitlabfake.DefaultBranch))
+
+	_, err = applyResource(p, "gitlabcommit_directory", state, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/build.log"}, server.Files("1", gitlabfake.DefaultBranch))
+}
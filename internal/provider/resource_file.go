@@ -2,16 +2,19 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/avast/retry-go"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/xanzy/go-gitlab"
-	"net/http"
+	"io"
 	"os"
-	"time"
+	"strings"
+	"unicode/utf8"
 )
 
 func resourceGitlabCommit() *schema.Resource {
@@ -23,6 +26,10 @@ func resourceGitlabCommit() *schema.Resource {
 		ReadContext:   resourceGitlabcommitRead,
 		UpdateContext: resourceGitlabcommitUpdate,
 		DeleteContext: resourceGitlabcommitDelete,
+		CustomizeDiff: resourceGitlabcommitCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitlabcommitImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"file_path": {
@@ -31,8 +38,103 @@ func resourceGitlabCommit() *schema.Resource {
 				ForceNew: true,
 			},
 			"content": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "content_base64", "source"},
+				Description:  "The UTF-8 encoded content of the file.",
+			},
+			"content_base64": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "content_base64", "source"},
+				ValidateFunc: validation.StringIsBase64,
+				Description:  "The base64 encoded content of the file, use this for binary files.",
+			},
+			"source": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "content_base64", "source"},
+				Description:  "Path to a local file to commit. Only the `content_sha256` of the file is stored in the state, use this for large files.",
+			},
+			"commit_message": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Added to the commit message as a bullet point below the provider `commit_message`. Changing it alone does not create a commit.",
+			},
+			"co_author": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(coAuthorRegexp, "must be in the format `Name <email>`"),
+				Description:  "Added to the commit message as a `Co-authored-by` trailer, in the format `Name <email>`.",
+			},
+			"author_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The author name of the commit. Defaults to the provider `author_name`. Files with different authors are committed separately.",
+			},
+			"author_email": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The author email of the commit. Defaults to the provider `author_email`. Files with different authors are committed separately.",
+			},
+			"content_sha256": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The hex encoded SHA-256 of the file content. Changes are detected with this when using `source`.",
+			},
+			"project_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The project of the file. Defaults to the provider `project_id`.",
+			},
+			"branch": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The branch of the file. Defaults to the provider `branch`.",
+			},
+			"commit_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA of the commit that last changed the file through this resource.",
+			},
+			"commit_url": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The web URL of the commit given by `commit_id`.",
+			},
+			"blob_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The blob ID of the file content.",
+			},
+			"merge_request_iid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The IID of the merge request containing the commit given by `commit_id`, when using the provider `merge_request` block.",
+			},
+			"merge_request_url": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The web URL of the merge request given by `merge_request_iid`.",
+			},
+			"planned_diff": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The unified diff from the file in the repository to the planned content, only set in the plan of an update. Starts with a comment if the file has been changed outside of Terraform since the last apply.",
+			},
+			"changed_outside": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the file has been changed outside of Terraform since the last apply, i.e. `last_commit_id` differs from `commit_id`.",
+			},
+			"last_commit_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA of the last commit that changed the file, as returned by the GitLab files API.",
 			},
 		},
 	}
@@ -42,7 +144,36 @@ func resourceGitlabcommitRead(ctx context.Context, d *schema.ResourceData, meta
 	client := meta.(*client)
 	filePath := d.Id()
 
-	repositoryFile, err := getFile(filePath, client.branch, client.projectId, client.gitlab)
+	projectId, branch := resourceLocation(d, client)
+	setLocation(d, client)
+	branch, err := mergeRequestBranch(ctx, d, client, projectId, branch)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// the state is kept if the blob has not changed, so an unchanged file is served from the listing of the tree.
+	// The last_commit_id is kept as well, a change reverted outside of Terraform is not noticed since the content is the same.
+	location := commitLocation{projectId: projectId, branch: branch}
+	if d.Get("commit_id").(string) != "" {
+		unchanged, err := client.reads.unchanged(ctx, location, filePath, d.Get("blob_id").(string))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if unchanged {
+			logD("[RESOURCE] " + filePath + " is unchanged, skipping fetching it")
+			d.Set("planned_diff", "")
+			return nil
+		}
+	}
+
+	// the content is not stored in the state when using source, so there is no need to transfer it
+	get := getFile
+	useSource := d.Get("source").(string) != ""
+	if useSource {
+		get = getFileMetaData
+	}
+
+	repositoryFile, err := get(ctx, filePath, branch, projectId, client)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			logD(fmt.Sprintf("file %s not found, removing from state", filePath))
@@ -53,51 +184,249 @@ func resourceGitlabcommitRead(ctx context.Context, d *schema.ResourceData, meta
 	}
 
 	d.SetId(repositoryFile.FilePath)
-	content, err := base64.StdEncoding.DecodeString(repositoryFile.Content)
-	if err != nil {
-		return diag.FromErr(fmt.Errorf("unable to decode content: %w", err))
+	d.Set("file_path", repositoryFile.FilePath)
+	if !useSource {
+		content, err := base64.StdEncoding.DecodeString(repositoryFile.Content)
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("unable to decode content: %w", err))
+		}
+		setContent(d, content)
 	}
-	d.Set("content", string(content))
+	d.Set("content_sha256", repositoryFile.SHA256)
+	d.Set("blob_id", repositoryFile.BlobID)
+	d.Set("last_commit_id", repositoryFile.LastCommitID)
+
+	// the commit is unknown if the file was not committed by this resource, e.g. if it already existed
+	if d.Get("commit_id").(string) == "" {
+		commit, err := client.repository.GetCommit(ctx, projectId, repositoryFile.LastCommitID)
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("unable to get commit %s: %w", repositoryFile.LastCommitID, err))
+		}
+		setCommit(d, commit)
+	}
+	d.Set("changed_outside", repositoryFile.LastCommitID != d.Get("commit_id").(string))
+
+	// the diff only describes a planned change
+	d.Set("planned_diff", "")
 
 	return nil
 }
 
+// resourceGitlabcommitImport imports a file by its path, or by "<project>:<branch>:<path>" for other projects and branches than the provider is configured with
+func resourceGitlabcommitImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	projectId, branch, filePath, err := parseFileId(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	d.SetId(filePath)
+	d.Set("file_path", filePath)
+	d.Set("project_id", projectId)
+	d.Set("branch", branch)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// parseFileId parses an import ID in the form "<path>" or "<project>:<branch>:<path>".
+// Project and branch are empty for the first form. Branch names cannot contain a colon, so the path is everything after the second colon.
+func parseFileId(id string) (projectId, branch, filePath string, err error) {
+	parts := strings.SplitN(id, ":", 3)
+	if len(parts) != 3 {
+		return "", "", id, nil
+	}
+	if parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid ID '%s', expected '<path>' or '<project>:<branch>:<path>'", id)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// resourceLocation returns the project and branch of the resource, which defaults to the provider configuration
+func resourceLocation(d resourceGetter, client *client) (projectId, branch string) {
+	projectId, branch = client.projectId, client.branch
+	if v := d.Get("project_id").(string); v != "" {
+		projectId = v
+	}
+	if v := d.Get("branch").(string); v != "" {
+		branch = v
+	}
+	return projectId, branch
+}
+
+// setLocation stores the project and branch of the resource in the state, so changing the provider defaults does not move existing resources
+func setLocation(d *schema.ResourceData, client *client) {
+	projectId, branch := resourceLocation(d, client)
+	d.Set("project_id", projectId)
+	d.Set("branch", branch)
+}
+
+// resourceAuthor returns the author of the commit, which defaults to the provider configuration
+func resourceAuthor(d *schema.ResourceData, client *client) commitAuthor {
+	author := client.author
+	if v := d.Get("author_name").(string); v != "" {
+		author.name = v
+	}
+	if v := d.Get("author_email").(string); v != "" {
+		author.email = v
+	}
+	return author
+}
+
 func resourceGitlabcommitCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	err := applyAction(gitlab.FileAction(gitlab.FileCreate), meta.(*client), d)
+	client := meta.(*client)
+	filePath := d.Get("file_path").(string)
+	projectId, branch := resourceLocation(d, client)
+
+	// an existing file is taken over by updating it, creating it would fail the commit of the whole batch
+	action := gitlab.FileAction(gitlab.FileCreate)
+	_, err := client.repository.GetFileMetaData(ctx, projectId, filePath, branch)
+	switch {
+	case err == nil:
+		logD("[RESOURCE] " + filePath + " already exists, updating it")
+		action = gitlab.FileAction(gitlab.FileUpdate)
+	case !errors.Is(err, os.ErrNotExist):
+		return diag.FromErr(fmt.Errorf("unable to check whether %s exists: %w", filePath, err))
+	}
+
+	resp, err := applyAction(ctx, action, client, d)
 	if err != nil {
-		return diag.FromErr(err)
+		return diagFromCommitErr(filePath, err)
 	}
 
-	d.SetId(d.Get("file_path").(string))
+	d.SetId(filePath)
 	d.Set("content", d.Get("content"))
+	setLocation(d, client)
+	setCommit(d, resp.commit)
+	setMergeRequest(d, resp.mergeRequest)
 
+	// the file is not in the repository in a dry run, so the planned state is kept
+	if client.dryRun {
+		return nil
+	}
 	return resourceGitlabcommitRead(ctx, d, meta)
 }
 
 func resourceGitlabcommitUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	err := applyAction(gitlab.FileAction(gitlab.FileUpdate), meta.(*client), d)
+	// the commit attributes only apply to the next commit of the file
+	if !d.HasChanges("content", "content_base64", "source", "content_sha256") {
+		return resourceGitlabcommitRead(ctx, d, meta)
+	}
+
+	resp, err := applyAction(ctx, gitlab.FileAction(gitlab.FileUpdate), meta.(*client), d)
 	if err != nil {
-		return diag.FromErr(err)
+		// the commit failed so the state must keep the content that is still in the repository
+		d.Partial(true)
+		return diagFromCommitErr(d.Id(), err)
 	}
 
 	d.SetId(d.Get("file_path").(string))
 	d.Set("content", d.Get("content"))
+	setCommit(d, resp.commit)
+	setMergeRequest(d, resp.mergeRequest)
+	if meta.(*client).dryRun {
+		return nil
+	}
 	return resourceGitlabcommitRead(ctx, d, meta)
 }
 
 func resourceGitlabcommitDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	err := applyAction(gitlab.FileAction(gitlab.FileDelete), meta.(*client), d)
+	_, err := applyAction(ctx, gitlab.FileAction(gitlab.FileDelete), meta.(*client), d)
 	if err != nil {
-		return diag.FromErr(err)
+		return diagFromCommitErr(d.Id(), err)
 	}
 
 	d.SetId("")
 	return nil
 }
 
-func applyAction(action *gitlab.FileActionValue, client *client, d *schema.ResourceData) error {
+// setContent sets the content in the attribute used by the resource.
+// If none is used, e.g. when importing, content is used for UTF-8 text and content_base64 for everything else.
+func setContent(d *schema.ResourceData, content []byte) {
+	useBase64 := d.Get("content_base64").(string) != ""
+	if !useBase64 && d.Get("content").(string) == "" {
+		useBase64 = !utf8.Valid(content)
+	}
+
+	if useBase64 {
+		d.Set("content_base64", base64.StdEncoding.EncodeToString(content))
+		return
+	}
+	d.Set("content", string(content))
+}
+
+// resourceGitlabcommitCustomizeDiff sets the planned content_sha256 and planned_diff, and marks the commit attributes as unknown when the content will be committed
+func resourceGitlabcommitCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if err := setPlannedContentSHA256(d); err != nil {
+		return err
+	}
+	client, _ := meta.(*client)
+	if err := setPlannedDiff(ctx, d, client); err != nil {
+		return err
+	}
+
+	if d.Id() == "" || !(d.HasChange("content") || d.HasChange("content_base64") || d.HasChange("content_sha256")) {
+		return nil
+	}
+	for _, key := range []string{"commit_id", "commit_url", "blob_id", "last_commit_id", "merge_request_iid", "merge_request_url", "changed_outside"} {
+		if err := d.SetNewComputed(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPlannedContentSHA256 sets content_sha256 from the configured content.
+// The content of a source is not in the state, a change of content_sha256 is therefore the only diff when the source file changes.
+func setPlannedContentSHA256(d *schema.ResourceDiff) error {
+	for _, key := range []string{"content", "content_base64", "source"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("content_sha256")
+		}
+	}
+
+	var content []byte
+	switch {
+	case d.Get("source").(string) != "":
+		hash, err := fileSHA256(d.Get("source").(string))
+		if err != nil {
+			return err
+		}
+		return setNewIfChanged(d, "content_sha256", hash)
+	case d.Get("content_base64").(string) != "":
+		var err error
+		content, err = base64.StdEncoding.DecodeString(d.Get("content_base64").(string))
+		if err != nil {
+			return fmt.Errorf("unable to decode content_base64: %w", err)
+		}
+	default:
+		content = []byte(d.Get("content").(string))
+	}
+
+	hash := sha256.Sum256(content)
+	return setNewIfChanged(d, "content_sha256", hex.EncodeToString(hash[:]))
+}
+
+func setNewIfChanged(d *schema.ResourceDiff, key string, value interface{}) error {
+	if d.Get(key) == value {
+		return nil
+	}
+	return d.SetNew(key, value)
+}
+
+// setCommit sets the commit attributes, commit is nil when the action did not create a commit
+func setCommit(d *schema.ResourceData, commit *gitlab.Commit) {
+	if commit == nil {
+		d.Set("commit_id", "")
+		d.Set("commit_url", "")
+		return
+	}
+	d.Set("commit_id", commit.ID)
+	d.Set("commit_url", commit.WebURL)
+}
+
+func applyAction(ctx context.Context, action *gitlab.FileActionValue, client *client, d *schema.ResourceData) (*responseSync, error) {
 	filePath := d.Get("file_path").(string)
 	content := d.Get("content").(string)
+	projectId, branch := resourceLocation(d, client)
 
 	gitlabAction := &gitlab.CommitActionOptions{
 		Action:   action,
@@ -105,56 +434,92 @@ func applyAction(action *gitlab.FileActionValue, client *client, d *schema.Resou
 		Content:  gitlab.String(content),
 	}
 
-	logD("[RESOURCE] applying " + *gitlabAction.FilePath)
-	client.actionCh <- gitlabAction
-
-	return waitForResponse(
-		*gitlabAction.FilePath,
-		client.responseSyncCh,
-	)
-}
-
-// waitForResponse listens for response from the actionSyncronizer
-func waitForResponse(filePath string, responseSyncCh chan *responseSync) error {
-	logD("[RESOURCE] will start waiting for response " + filePath)
-	for {
-		resp := <-responseSyncCh
-		if resp.filePath == filePath {
-			logD("[RESOURCE] received my own filepath: " + resp.filePath)
-			if resp.err != nil {
-				return resp.err
-			}
-			return nil
+	if contentBase64 := d.Get("content_base64").(string); contentBase64 != "" {
+		gitlabAction.Content = gitlab.String(contentBase64)
+		gitlabAction.Encoding = gitlab.String("base64")
+	}
+
+	if source := d.Get("source").(string); source != "" && *action != gitlab.FileDelete {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read source: %w", err)
 		}
-		logD("[RESOURCE] resource '" + filePath + "' got '" + resp.filePath + "' sending back to synchronizer")
-		responseSyncCh <- resp
+		gitlabAction.Content = gitlab.String(base64.StdEncoding.EncodeToString(content))
+		gitlabAction.Encoding = gitlab.String("base64")
 	}
+
+	// the last commit ID from the state makes GitLab reject the commit if the file has been changed outside of Terraform
+	if client.conflictStrategy == conflictStrategyFail && *action != gitlab.FileCreate {
+		lastCommitID, _ := d.GetChange("last_commit_id")
+		if lastCommitID.(string) != "" {
+			gitlabAction.LastCommitID = gitlab.String(lastCommitID.(string))
+		}
+	}
+
+	logD("[RESOURCE] applying " + *gitlabAction.FilePath)
+	return sendRequest(ctx, client, &commitRequest{
+		// the same path can be managed in several projects and branches
+		id:       projectId + ":" + branch + ":" + filePath,
+		location: commitLocation{projectId: projectId, branch: branch},
+		author:   resourceAuthor(d, client),
+		message:  d.Get("commit_message").(string),
+		coAuthor: d.Get("co_author").(string),
+		actions:  []*gitlab.CommitActionOptions{gitlabAction},
+	})
 }
 
-func getFile(filePath, branch, projectId string, client *gitlab.Client) (*gitlab.File, error) {
-	options := &gitlab.GetFileOptions{
-		Ref: gitlab.String(branch),
+// sendRequest sends the request to the actionSyncronizer and returns the response when the commit containing the actions is created.
+// It stops waiting when ctx is done, the commit is still created if the request has been sent.
+func sendRequest(ctx context.Context, client *client, request *commitRequest) (*responseSync, error) {
+	request.reply = make(chan *responseSync, 1)
+	select {
+	case client.actionCh <- request:
+	case <-client.done:
+		return nil, errProviderStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	var repositoryFile *gitlab.File
-	var resp *gitlab.Response
 
-	// A resource might finish before the provider commits the files therefore we need to retry until file is committed
-	err := retry.Do(func() error {
-		var err error
-		repositoryFile, resp, err = client.RepositoryFiles.GetFile(projectId, filePath, options)
-		if err != nil {
-			if resp.StatusCode == http.StatusNotFound {
-				return os.ErrNotExist
-			}
-			return err
+	return waitForResponse(ctx, request)
+}
+
+// waitForResponse waits for the response of the actionSyncronizer to the request
+func waitForResponse(ctx context.Context, request *commitRequest) (*responseSync, error) {
+	logD("[RESOURCE] will start waiting for response " + request.id)
+	select {
+	case resp := <-request.reply:
+		logD("[RESOURCE] received response for " + request.id)
+		if resp.err != nil {
+			return nil, resp.err
 		}
-		return nil
-	},
-		retry.MaxDelay(600*time.Millisecond),
-		retry.Delay(300*time.Millisecond),
-		retry.RetryIf(func(err error) bool {
-			return errors.Is(err, os.ErrNotExist)
-		}))
-
-	return repositoryFile, err
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("stopped waiting for the commit of %s: %w", request.id, ctx.Err())
+	}
+}
+
+// getFile returns the file from the repository, the error wraps os.ErrNotExist if it does not exist.
+// The file is not polled for since it exists once the commit creating it has returned.
+func getFile(ctx context.Context, filePath, branch, projectId string, client *client) (*gitlab.File, error) {
+	return client.repository.GetFile(ctx, projectId, filePath, branch)
+}
+
+// getFileMetaData is like getFile, but without transferring the content
+func getFileMetaData(ctx context.Context, filePath, branch, projectId string, client *client) (*gitlab.File, error) {
+	return client.repository.GetFileMetaData(ctx, projectId, filePath, branch)
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file content, the same format as the content_sha256 from the GitLab files API
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to open source: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to read source: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -1,13 +1,20 @@
 package provider
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/akselleirv/terraform-provider-gitlabcommit/internal/gitlabfake"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"github.com/stretchr/testify/assert"
 	"github.com/xanzy/go-gitlab"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -17,54 +24,81 @@ import (
 )
 
 func TestAccResourceFile_create_one_file(t *testing.T) {
-	client := testAccProvider.Meta().(*client)
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	filePath := "dir/test-" + acctest.RandString(4) + ".txt"
+
 	resource.Test(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
 		ProviderFactories: testAccProviderFactories,
-		CheckDestroy:      testAccCheckgitlabcommitFileDestroy(client, "gitlabcommit_file.test", client.projectId),
+		CheckDestroy:      testAccCheckgitlabcommitFileDestroy(server, filePath),
 		Steps: []resource.TestStep{
 			{
-				Config: testAccResourceFileSimple(),
+				Config: testAccProviderConfig(server) + testAccResourceFileSimple(filePath, "this is a test file"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckgitlabcommitFileExists(server, filePath, "this is a test file"),
+					resource.TestCheckResourceAttr("gitlabcommit_file.test", "changed_outside", "false"),
+				),
+			},
+			{
+				Config: testAccProviderConfig(server) + testAccResourceFileSimple(filePath, "this is a changed test file"),
 				Check: resource.ComposeTestCheckFunc(
-					testAccCheckgitlabcommitFileExists(client, "gitlabcommit_file.test", client.projectId),
+					testAccCheckgitlabcommitFileExists(server, filePath, "this is a changed test file"),
 				),
 			},
 		},
 	})
 }
 
-/*
-This test is not supported. See issue: https://github.com/hashicorp/terraform-plugin-sdk/issues/536
 func TestAccResourceFile_create_many_files(t *testing.T) {
-	client := testAccProvider.Meta().(*client)
+	server := gitlabfake.NewServer()
+	defer server.Close()
 	fileNames := []string{"dir/file-" + acctest.RandString(4), "dir/file-" + acctest.RandString(4), "dir/file-" + acctest.RandString(4)}
+
 	resource.Test(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
 		ProviderFactories: testAccProviderFactories,
-		CheckDestroy:      testAccCheckgitlabcommitFileDestroyMany(client, fileNames, client.projectId),
+		CheckDestroy:      testAccCheckgitlabcommitFileDestroyMany(server, fileNames),
 		Steps: []resource.TestStep{
 			{
-				Config: testAccResourceFileMany(fileNames[0], fileNames[1], fileNames[2]),
+				Config: testAccProviderConfig(server) + testAccResourceFileMany(fileNames[0], fileNames[1], fileNames[2]),
 				Check: resource.ComposeTestCheckFunc(
-					testAccCheckgitlabcommitFileExistsMany(client, fileNames, client.projectId),
+					testAccCheckgitlabcommitFileExistsMany(server, fileNames),
 				),
 			},
 		},
 	})
 }
 
-*/
+func TestAccResourceFile_ref_race(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	fileNames := []string{"race/file-" + acctest.RandString(4), "race/file-" + acctest.RandString(4), "race/file-" + acctest.RandString(4)}
+	server.Fail(gitlabfake.RefRace(gitlabfake.DefaultBranch, 2))
+	server.Fail(gitlabfake.RateLimited(1, 1))
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckgitlabcommitFileDestroyMany(server, fileNames),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccProviderConfig(server) + testAccResourceFileMany(fileNames[0], fileNames[1], fileNames[2]),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckgitlabcommitFileExistsMany(server, fileNames),
+				),
+			},
+		},
+	})
+}
 
 func TestWaitForResponse(t *testing.T) {
 	var (
 		numberOfResources = 10
 		debounce          = 50 * time.Millisecond
 
-		actionCh       = make(chan *gitlab.CommitActionOptions)
-		responseSyncCh = make(chan *responseSync)
+		actionCh = make(chan *commitRequest)
 
 		resourceWaitGroup = &sync.WaitGroup{}
 
+		mu             sync.Mutex
 		errorsReceived []error
 		inputActions   []*gitlab.CommitActionOptions
 	)
@@ -78,42 +112,319 @@ func TestWaitForResponse(t *testing.T) {
 
 	expectedErr := errors.New("this is an expected error")
 
-	doCommit := func(actualActions []*gitlab.CommitActionOptions) error {
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		actualActions := batch.actions()
 		assert.ElementsMatch(t, inputActions, actualActions)
-		return expectedErr
+		return nil, nil, expectedErr
 	}
 
 	// Start action synchronizer
-	go actionSyncronizer(debounce, actionCh, responseSyncCh, doCommit)
+	go actionSyncronizer(context.Background(), debounce, 0, commitLimits{}, actionCh, doCommit)
 
 	// Start goroutines that is listening on channels
 	resourceWaitGroup.Add(numberOfResources)
 	for i := 0; i < numberOfResources; i++ {
 		go func(index int, filePath string) {
 			defer resourceWaitGroup.Done()
-			actionCh <- inputActions[index]
-			errorsReceived = append(errorsReceived, waitForResponse(filePath, responseSyncCh))
+			request := &commitRequest{id: filePath, reply: make(chan *responseSync, 1), actions: []*gitlab.CommitActionOptions{inputActions[index]}}
+			actionCh <- request
+			_, err := waitForResponse(context.Background(), request)
+			mu.Lock()
+			errorsReceived = append(errorsReceived, err)
+			mu.Unlock()
 		}(i, *inputActions[i].FilePath)
 	}
 
 	// validate if error handling is working as expected
 	resourceWaitGroup.Wait()
-	// only last error should contain an error
-	for _, err := range errorsReceived[:numberOfResources-1] {
-		assert.NoError(t, err)
+	// every resource in the failed commit should receive the error
+	assert.Len(t, errorsReceived, numberOfResources)
+	for _, err := range errorsReceived {
+		assert.ErrorIs(t, err, expectedErr)
+	}
+
+}
+
+func TestResourceFileReadCommitAttributes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/files/dir/file.txt":
+			fmt.Fprint(w, `{"file_path":"dir/file.txt","content":"Y29udGVudA==","blob_id":"blob","last_commit_id":"last"}`)
+		case "/api/v4/projects/1/repository/commits/last":
+			fmt.Fprint(w, `{"id":"last","web_url":"https://gitlab.example.com/commit/last"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	c := &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient), projectId: "1", branch: "main"}
+
+	tests := []struct {
+		name                   string
+		commitId               string
+		expectedCommitId       string
+		expectedCommitURL      string
+		expectedChangedOutside bool
+	}{
+		{
+			name:              "unknown commit is set from last commit",
+			expectedCommitId:  "last",
+			expectedCommitURL: "https://gitlab.example.com/commit/last",
+		},
+		{
+			name:                   "known commit is kept",
+			commitId:               "mine",
+			expectedCommitId:       "mine",
+			expectedChangedOutside: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{})
+			d.SetId("dir/file.txt")
+			d.Set("commit_id", tt.commitId)
+
+			diags := resourceGitlabcommitRead(context.Background(), d, c)
+			assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+			assert.Equal(t, "content", d.Get("content"))
+			assert.Equal(t, "blob", d.Get("blob_id"))
+			assert.Equal(t, "last", d.Get("last_commit_id"))
+			assert.Equal(t, tt.expectedCommitId, d.Get("commit_id"))
+			assert.Equal(t, tt.expectedCommitURL, d.Get("commit_url"))
+			assert.Equal(t, tt.expectedChangedOutside, d.Get("changed_outside"))
+		})
+	}
+}
+
+func TestSetContent(t *testing.T) {
+	binary := []byte{0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0xff, 0x00}
+
+	tests := []struct {
+		name                  string
+		config                map[string]interface{}
+		content               []byte
+		expectedContent       string
+		expectedContentBase64 string
+	}{
+		{
+			name:            "text with content",
+			config:          map[string]interface{}{"content": "old"},
+			content:         []byte("new"),
+			expectedContent: "new",
+		},
+		{
+			name:                  "binary with content_base64",
+			config:                map[string]interface{}{"content_base64": "b2xk"},
+			content:               binary,
+			expectedContentBase64: base64.StdEncoding.EncodeToString(binary),
+		},
+		{
+			name:                  "text with content_base64",
+			config:                map[string]interface{}{"content_base64": "b2xk"},
+			content:               []byte("new"),
+			expectedContentBase64: "bmV3",
+		},
+		{
+			name:            "text without configuration",
+			config:          map[string]interface{}{},
+			content:         []byte("new"),
+			expectedContent: "new",
+		},
+		{
+			name:                  "binary without configuration",
+			config:                map[string]interface{}{},
+			content:               binary,
+			expectedContentBase64: base64.StdEncoding.EncodeToString(binary),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, tt.config)
+			setContent(d, tt.content)
+			assert.Equal(t, tt.expectedContent, d.Get("content"))
+			assert.Equal(t, tt.expectedContentBase64, d.Get("content_base64"))
+		})
 	}
+}
+
+func TestApplyActionContentBase64(t *testing.T) {
+	var (
+		actionCh = make(chan *commitRequest)
+		c        = &client{projectId: "1", branch: "main", actionCh: actionCh}
+	)
 
-	assert.Error(t, errorsReceived[numberOfResources-1])
+	d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{
+		"file_path":      "image.png",
+		"content_base64": "iVBORw0KGgo=",
+	})
 
+	go func() {
+		request := <-actionCh
+		assert.Equal(t, "1:main:image.png", request.id)
+		assert.Equal(t, "iVBORw0KGgo=", *request.actions[0].Content)
+		assert.Equal(t, "base64", *request.actions[0].Encoding)
+		request.reply <- &responseSync{}
+	}()
+
+	_, err := applyAction(context.Background(), gitlab.FileAction(gitlab.FileCreate), c, d)
+	assert.NoError(t, err)
 }
 
-func testAccResourceFileSimple() string {
+func TestResourceFileDiffSource(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "bundle.js")
+	assert.NoError(t, os.WriteFile(source, []byte("content"), 0600))
+	// echo -n content | sha256sum
+	hash := "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"file_path": "dist/bundle.js",
+		"source":    source,
+	})
+
+	diff, err := resourceGitlabCommit().Diff(context.Background(), nil, config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, diff.Attributes["content_sha256"].New)
+
+	state := &terraform.InstanceState{
+		ID: "dist/bundle.js",
+		Attributes: map[string]string{
+			"id":             "dist/bundle.js",
+			"file_path":      "dist/bundle.js",
+			"source":         source,
+			"content_sha256": hash,
+		},
+	}
+	diff, err = resourceGitlabCommit().Diff(context.Background(), state, config, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, diff, "unchanged source should not have a diff")
+
+	assert.NoError(t, os.WriteFile(source, []byte("changed"), 0600))
+	diff, err = resourceGitlabCommit().Diff(context.Background(), state, config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, diff.Attributes["content_sha256"].Old)
+	assert.NotEqual(t, hash, diff.Attributes["content_sha256"].New)
+	assert.True(t, diff.Attributes["commit_id"].NewComputed)
+}
+
+func TestResourceFileReadSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/" && r.Method != http.MethodHead {
+			t.Errorf("expected only metadata to be read, got %s", r.Method)
+		}
+		w.Header().Set("X-Gitlab-File-Path", "dist/bundle.js")
+		w.Header().Set("X-Gitlab-Content-Sha256", "remote")
+		w.Header().Set("X-Gitlab-Last-Commit-Id", "last")
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	c := &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient), projectId: "1", branch: "main"}
+
+	d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{
+		"file_path": "dist/bundle.js",
+		"source":    "bundle.js",
+	})
+	d.SetId("dist/bundle.js")
+	d.Set("commit_id", "mine")
+
+	diags := resourceGitlabcommitRead(context.Background(), d, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	assert.Equal(t, "remote", d.Get("content_sha256"))
+	assert.Equal(t, "", d.Get("content"))
+	assert.Equal(t, "", d.Get("content_base64"))
+}
+
+func TestParseFileId(t *testing.T) {
+	tests := []struct {
+		id                string
+		expectedProjectId string
+		expectedBranch    string
+		expectedFilePath  string
+		wantErr           bool
+	}{
+		{id: "dir/file.txt", expectedFilePath: "dir/file.txt"},
+		{id: "dir/file:1.txt", expectedFilePath: "dir/file:1.txt"},
+		{id: "42:main:dir/file.txt", expectedProjectId: "42", expectedBranch: "main", expectedFilePath: "dir/file.txt"},
+		{id: "group/project:feature/x:dir/file:1.txt", expectedProjectId: "group/project", expectedBranch: "feature/x", expectedFilePath: "dir/file:1.txt"},
+		{id: "42::dir/file.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			projectId, branch, filePath, err := parseFileId(tt.id)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.expectedProjectId, projectId)
+			assert.Equal(t, tt.expectedBranch, branch)
+			assert.Equal(t, tt.expectedFilePath, filePath)
+		})
+	}
+}
+
+func TestResourceFileImport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v4/projects/group/project/repository/files/dir/file.txt" && r.URL.Query().Get("ref") == "feature":
+			fmt.Fprint(w, `{"file_path":"dir/file.txt","content":"Y29udGVudA==","last_commit_id":"last"}`)
+		case r.URL.Path == "/api/v4/projects/1/repository/files/dir/file.txt" && r.URL.Query().Get("ref") == "main":
+			fmt.Fprint(w, `{"file_path":"dir/file.txt","content":"ZGVmYXVsdA==","last_commit_id":"last"}`)
+		case strings.HasSuffix(r.URL.Path, "/repository/commits/last"):
+			fmt.Fprint(w, `{"id":"last","web_url":"https://gitlab.example.com/commit/last"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+	c := &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient), projectId: "1", branch: "main"}
+
+	tests := []struct {
+		id                string
+		expectedProjectId string
+		expectedBranch    string
+		expectedContent   string
+	}{
+		// the provider defaults are stored, so the resource is not moved when they change
+		{id: "dir/file.txt", expectedProjectId: "1", expectedBranch: "main", expectedContent: "default"},
+		{id: "group/project:feature:dir/file.txt", expectedProjectId: "group/project", expectedBranch: "feature", expectedContent: "content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			d := resourceGitlabCommit().Data(&terraform.InstanceState{ID: tt.id})
+
+			imported, err := resourceGitlabCommit().Importer.StateContext(context.Background(), d, c)
+			assert.NoError(t, err)
+			assert.Len(t, imported, 1)
+
+			diags := resourceGitlabcommitRead(context.Background(), imported[0], c)
+			assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+			assert.Equal(t, "dir/file.txt", imported[0].Id())
+			assert.Equal(t, "dir/file.txt", imported[0].Get("file_path"))
+			assert.Equal(t, tt.expectedProjectId, imported[0].Get("project_id"))
+			assert.Equal(t, tt.expectedBranch, imported[0].Get("branch"))
+			assert.Equal(t, tt.expectedContent, imported[0].Get("content"))
+			assert.Equal(t, "last", imported[0].Get("commit_id"))
+		})
+	}
+}
+
+func testAccResourceFileSimple(filePath, content string) string {
 	return fmt.Sprintf(`
 resource "gitlabcommit_file" "test" {
-  file_path = "dir/test-%d.txt"
-  content   = "this is a test file #%d"
+  file_path = "%s"
+  content   = "%s"
 }
-`, acctest.RandInt(), acctest.RandInt())
+`, filePath, content)
 }
 
 func testAccResourceFileMany(fileNameOne, fileNameTwo, fileNameThree string) string {
@@ -132,73 +443,365 @@ resource "gitlabcommit_file" "test" {
 }`, fileNameOne, fileNameTwo, fileNameThree)
 }
 
-func testAccCheckgitlabcommitFileExists(c *client, resourceName, projectId string) resource.TestCheckFunc {
+func testAccCheckgitlabcommitFileExists(server *gitlabfake.Server, filePath, expectedContent string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		rs, ok := s.RootModule().Resources[resourceName]
+		content, ok := server.File("1", gitlabfake.DefaultBranch, filePath)
 		if !ok {
-			return fmt.Errorf("not found: %s", resourceName)
+			return fmt.Errorf("file %s not found", filePath)
 		}
-		fileId := rs.Primary.ID
-		err := fileExist(c, fileId, projectId)
-		if err != nil {
-			return err
+		if string(content) != expectedContent {
+			return fmt.Errorf("expected file %s to contain '%s', got '%s'", filePath, expectedContent, content)
 		}
-		return nil
+		return resource.TestCheckResourceAttr("gitlabcommit_file.test", "id", filePath)(s)
 	}
 }
 
-func testAccCheckgitlabcommitFileDestroy(c *client, resourceName, projectId string) resource.TestCheckFunc {
+func testAccCheckgitlabcommitFileDestroy(server *gitlabfake.Server, filePath string) resource.TestCheckFunc {
+	return testAccCheckgitlabcommitFileDestroyMany(server, []string{filePath})
+}
+
+func testAccCheckgitlabcommitFileExistsMany(server *gitlabfake.Server, filePaths []string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		rs, ok := s.RootModule().Resources[resourceName]
-		if !ok {
-			return fmt.Errorf("not found: %s", resourceName)
-		}
-		err := fileExist(c, rs.Primary.ID, projectId)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				return nil
+		for _, filePath := range filePaths {
+			if _, ok := server.File("1", gitlabfake.DefaultBranch, filePath); !ok {
+				return fmt.Errorf("file %s not found", filePath)
 			}
-			return err
 		}
-		return errors.New("expected to not find any file")
+		// the for_each resources are created in one commit
+		if commits := server.Commits("1", gitlabfake.DefaultBranch); len(commits) != 2 {
+			return fmt.Errorf("expected the files to be created in one commit, got %d commits", len(commits)-1)
+		}
+		return nil
 	}
 }
 
-func testAccCheckgitlabcommitFileExistsMany(c *client, filePaths []string, projectId string) resource.TestCheckFunc {
+func testAccCheckgitlabcommitFileDestroyMany(server *gitlabfake.Server, filePaths []string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		for _, fileName := range filePaths {
-			err := fileExist(c, fileName, projectId)
-			if err != nil {
-				return err
+		for _, filePath := range filePaths {
+			if _, ok := server.File("1", gitlabfake.DefaultBranch, filePath); ok {
+				return fmt.Errorf("expected file %s to be deleted", filePath)
 			}
 		}
-
 		return nil
 	}
 }
 
-func testAccCheckgitlabcommitFileDestroyMany(c *client, filePaths []string, projectId string) resource.TestCheckFunc {
-	return func(s *terraform.State) error {
-		for _, fileName := range filePaths {
-			err := fileExist(c, fileName, projectId)
-			if err != nil {
-				if errors.Is(err, os.ErrNotExist) {
-					return nil
-				}
-				return err
+func TestApplyActionLocation(t *testing.T) {
+	tests := []struct {
+		name             string
+		attributes       map[string]string
+		expectedId       string
+		expectedLocation commitLocation
+	}{
+		{
+			name:             "defaults to the provider project and branch",
+			attributes:       map[string]string{},
+			expectedId:       "1:main:file.txt",
+			expectedLocation: commitLocation{projectId: "1", branch: "main"},
+		},
+		{
+			name:             "overrides the provider branch",
+			attributes:       map[string]string{"branch": "develop"},
+			expectedId:       "1:develop:file.txt",
+			expectedLocation: commitLocation{projectId: "1", branch: "develop"},
+		},
+		{
+			name:             "overrides the provider project and branch",
+			attributes:       map[string]string{"project_id": "group/project", "branch": "develop"},
+			expectedId:       "group/project:develop:file.txt",
+			expectedLocation: commitLocation{projectId: "group/project", branch: "develop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				actionCh = make(chan *commitRequest)
+				c        = &client{projectId: "1", branch: "main", actionCh: actionCh}
+			)
+
+			raw := map[string]interface{}{"file_path": "file.txt", "content": "content"}
+			for k, v := range tt.attributes {
+				raw[k] = v
 			}
+			d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, raw)
+
+			go func() {
+				request := <-actionCh
+				assert.Equal(t, tt.expectedId, request.id)
+				assert.Equal(t, tt.expectedLocation, request.location)
+				request.reply <- &responseSync{}
+			}()
+
+			_, err := applyAction(context.Background(), gitlab.FileAction(gitlab.FileCreate), c, d)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestApplyActionCommitAttributes(t *testing.T) {
+	var (
+		actionCh = make(chan *commitRequest)
+		c        = &client{
+			projectId: "1",
+			branch:    "main",
+			author:    commitAuthor{name: "Terraform", email: "terraform@example.com"},
+			actionCh:  actionCh,
 		}
-		return errors.New("expected to not find any file")
+	)
+
+	d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{
+		"file_path":      "file.txt",
+		"content":        "content",
+		"commit_message": "add file.txt",
+		"co_author":      "Jane <jane@example.com>",
+		"author_name":    "John",
+	})
+
+	go func() {
+		request := <-actionCh
+		assert.Equal(t, commitAuthor{name: "John", email: "terraform@example.com"}, request.author)
+		assert.Equal(t, "add file.txt", request.message)
+		assert.Equal(t, "Jane <jane@example.com>", request.coAuthor)
+		request.reply <- &responseSync{}
+	}()
+
+	_, err := applyAction(context.Background(), gitlab.FileAction(gitlab.FileCreate), c, d)
+	assert.NoError(t, err)
+}
+
+func TestResourceFileCreateDryRun(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	var (
+		actionCh = make(chan *commitRequest)
+		// the file is not read back in a dry run, the repository is only used to check whether the file exists
+		c = &client{projectId: "1", branch: "main", dryRun: true, actionCh: actionCh, repository: newGitlabCommitter(gitlabClient)}
+	)
+
+	go func() {
+		request := <-actionCh
+		request.reply <- &responseSync{}
+	}()
+
+	d := schema.TestResourceDataRaw(t, resourceGitlabCommit().Schema, map[string]interface{}{
+		"file_path": "file.txt",
+		"content":   "content",
+	})
+	diags := resourceGitlabcommitCreate(context.Background(), d, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	assert.Equal(t, "file.txt", d.Id())
+	assert.Equal(t, "content", d.Get("content"))
+	assert.Equal(t, "", d.Get("commit_id"))
+}
+
+func TestWaitForResponseContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := waitForResponse(ctx, &commitRequest{id: "file.txt", reply: make(chan *responseSync, 1)})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSendRequestConcurrentResources(t *testing.T) {
+	defer checkGoroutines(t)()
+
+	var (
+		numberOfResources = 10000
+		ctx, cancel       = context.WithCancel(context.Background())
+		actionCh          = make(chan *commitRequest)
+		done              = make(chan struct{})
+		c                 = &client{actionCh: actionCh, done: done}
+		committedIn       = map[string]string{}
+	)
+
+	// the commits are split, so the resources receive different commits
+	doCommit := func(batch *batchCommit) (*gitlab.Commit, *gitlab.MergeRequest, error) {
+		id := fmt.Sprintf("commit-%d", batch.part)
+		for _, action := range batch.actions() {
+			committedIn[*action.FilePath] = id
+		}
+		return &gitlab.Commit{ID: id}, nil, nil
+	}
+	go func() {
+		defer close(done)
+		actionSyncronizer(ctx, time.Hour, numberOfResources, commitLimits{maxActions: 100}, actionCh, doCommit)
+	}()
+
+	received := make([]string, numberOfResources)
+	wg := sync.WaitGroup{}
+	wg.Add(numberOfResources)
+	for i := 0; i < numberOfResources; i++ {
+		go func(i int) {
+			defer wg.Done()
+			filePath := fmt.Sprintf("dir/file-%d.txt", i)
+			resp, err := sendRequest(context.Background(), c, &commitRequest{id: filePath, actions: []*gitlab.CommitActionOptions{{FilePath: gitlab.String(filePath)}}})
+			if assert.NoError(t, err) {
+				received[i] = resp.commit.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	cancel()
+	<-done
+
+	// every resource receives the commit containing its own action
+	assert.Len(t, committedIn, numberOfResources)
+	for i, commitID := range received {
+		assert.Equal(t, committedIn[fmt.Sprintf("dir/file-%d.txt", i)], commitID)
+	}
+}
+
+func TestResourceFileReadUnchangedBlob(t *testing.T) {
+	var (
+		server        = gitlabfake.NewServer()
+		numberOfFiles = 5
+		states        = make([]*terraform.InstanceState, numberOfFiles)
+	)
+	defer server.Close()
+
+	p, c := testProvider(t, server, map[string]interface{}{"expected_changes": numberOfFiles})
+	wg := sync.WaitGroup{}
+	wg.Add(numberOfFiles)
+	for i := 0; i < numberOfFiles; i++ {
+		go func(i int) {
+			defer wg.Done()
+			state, err := applyResource(p, "gitlabcommit_file", nil, map[string]interface{}{"file_path": fmt.Sprintf("dir-%d/file.txt", i), "content": "content"})
+			assert.NoError(t, err)
+			states[i] = state
+		}(i)
+	}
+	wg.Wait()
+	server.WriteFile("1", gitlabfake.DefaultBranch, "dir-0/file.txt", []byte("changed"))
+	c.reads.invalidate(commitLocation{projectId: "1", branch: gitlabfake.DefaultBranch})
+
+	requests := func() int {
+		return server.Requests(http.MethodGet, "") + server.Requests(http.MethodHead, "")
+	}
+	before := requests()
+	wg.Add(numberOfFiles)
+	for i := 0; i < numberOfFiles; i++ {
+		go func(i int) {
+			defer wg.Done()
+			state, diags := p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), states[i], c)
+			assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+			states[i] = state
+		}(i)
+	}
+	wg.Wait()
+
+	// the tree is listed once, only the changed file is fetched with its commit
+	assert.Equal(t, 2, requests()-before)
+	assert.Equal(t, "changed", states[0].Attributes["content"])
+	assert.Equal(t, "true", states[0].Attributes["changed_outside"])
+	for _, state := range states[1:] {
+		assert.Equal(t, "content", state.Attributes["content"])
+		assert.Equal(t, "false", state.Attributes["changed_outside"])
 	}
 }
 
-func fileExist(c *client, fileId, projectId string) error {
-	_, _, err := c.gitlab.RepositoryFiles.GetFile(projectId, fileId, &gitlab.GetFileOptions{Ref: gitlab.String("main")})
-	if err != nil {
-		if strings.Contains(err.Error(), "404 File Not Found") {
-			return os.ErrNotExist
+func TestResourceFileCreateStoresLocation(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+
+	p, _ := testProvider(t, server, nil)
+	config := map[string]interface{}{"file_path": "file.txt", "content": "content"}
+	state, err := applyResource(p, "gitlabcommit_file", nil, config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1", state.Attributes["project_id"])
+	assert.Equal(t, gitlabfake.DefaultBranch, state.Attributes["branch"])
+
+	// changing the provider default does not move the file
+	other, _ := testProvider(t, server, map[string]interface{}{"branch": "develop"})
+	diff, err := other.ResourcesMap["gitlabcommit_file"].Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), other.Meta())
+	assert.NoError(t, err)
+	assert.True(t, diff == nil || diff.Empty(), fmt.Sprintf("%+v", diff))
+
+	// setting the stored value explicitly does not replace the file
+	config["branch"] = gitlabfake.DefaultBranch
+	diff, err = p.ResourcesMap["gitlabcommit_file"].Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), p.Meta())
+	assert.NoError(t, err)
+	assert.True(t, diff == nil || diff.Empty(), fmt.Sprintf("%+v", diff))
+}
+
+func TestResourceFileReadDeletedFile(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+
+	// the default retry policy waits seconds between attempts
+	p, c := testProvider(t, server, nil)
+	state, err := applyResource(p, "gitlabcommit_file", nil, map[string]interface{}{"file_path": "file.txt", "content": "content"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	server.DeleteFile("1", gitlabfake.DefaultBranch, "file.txt")
+	c.reads.invalidate(commitLocation{projectId: "1", branch: gitlabfake.DefaultBranch})
+
+	// a missing file is gone at once instead of being polled for
+	start := time.Now()
+	state, diags := p.ResourcesMap["gitlabcommit_file"].RefreshWithoutUpgrade(context.Background(), state, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+	assert.Nil(t, state)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestResourceFileCreateExistingFile(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	server.WriteFile("1", gitlabfake.DefaultBranch, "exists.txt", []byte("x"))
+
+	// the existing file is updated to the configured content
+	p, _ := testProvider(t, server, nil)
+	state, err := applyResource(p, "gitlabcommit_file", nil, map[string]interface{}{"file_path": "exists.txt", "content": "new"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	content, _ := server.File("1", gitlabfake.DefaultBranch, "exists.txt")
+	assert.Equal(t, "new", string(content))
+	assert.Equal(t, "new", state.Attributes["content"])
+}
+
+func TestResourceFileFailedBatch(t *testing.T) {
+	server := gitlabfake.NewServer()
+	defer server.Close()
+	server.WriteFile("1", gitlabfake.DefaultBranch, "exists.txt", []byte("x"))
+	server.WriteFile("1", gitlabfake.DefaultBranch, "todelete.txt", []byte("delete me"))
+	head, _ := server.Branch("1", gitlabfake.DefaultBranch)
+
+	_, c := testProvider(t, server, map[string]interface{}{"expected_changes": 2})
+	defer c.close()
+
+	// the create is rejected since the file was created after the resource checked for it, which fails the whole commit
+	actions := []*gitlab.CommitActionOptions{
+		{Action: gitlab.FileAction(gitlab.FileCreate), FilePath: gitlab.String("exists.txt"), Content: gitlab.String("new")},
+		{Action: gitlab.FileAction(gitlab.FileDelete), FilePath: gitlab.String("todelete.txt")},
+	}
+	errs := make([]error, len(actions))
+	wg := sync.WaitGroup{}
+	wg.Add(len(actions))
+	for i, action := range actions {
+		go func(i int, action *gitlab.CommitActionOptions) {
+			defer wg.Done()
+			_, errs[i] = sendRequest(context.Background(), c, &commitRequest{
+				id:       *action.FilePath,
+				location: commitLocation{projectId: "1", branch: gitlabfake.DefaultBranch},
+				actions:  []*gitlab.CommitActionOptions{action},
+			})
+		}(i, action)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if assert.Error(t, err, *actions[i].FilePath) {
+			assert.Contains(t, err.Error(), "A file with this name already exists")
 		}
-		return fmt.Errorf("cannot get file: %v", err)
 	}
-	return nil
+	after, _ := server.Branch("1", gitlabfake.DefaultBranch)
+	assert.Equal(t, head, after, "nothing should be committed")
+	assert.Equal(t, []string{"exists.txt", "todelete.txt"}, server.Files("1", gitlabfake.DefaultBranch))
 }
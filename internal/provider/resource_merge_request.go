@@ -0,0 +1,383 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/xanzy/go-gitlab"
+)
+
+// dryRunMergeRequestId is the ID of merge requests created in a dry run
+const dryRunMergeRequestId = "0"
+
+func resourceGitlabCommitMergeRequest() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "The merge request resource will open a merge request for the changes committed to `source_branch` by the other resources. " +
+			"It is created after the batch containing the changes, so the merge request contains the exact commit given by `sha`.",
+
+		CreateContext: resourceGitlabcommitMergeRequestCreate,
+		ReadContext:   resourceGitlabcommitMergeRequestRead,
+		UpdateContext: resourceGitlabcommitMergeRequestUpdate,
+		DeleteContext: resourceGitlabcommitMergeRequestDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"project_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The project of the merge request. Defaults to the provider `project_id`.",
+			},
+			"source_branch": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The branch the changes are committed to, i.e. the `branch` of the file and directory resources. The branch must exist.",
+			},
+			"target_branch": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The branch the changes are merged into. Defaults to the provider `branch`.",
+			},
+			"title": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"description": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"remove_source_branch": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Remove the source branch when the merge request is merged.",
+			},
+			"merge_when_pipeline_succeeds": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Merge the merge request when the pipeline for `sha` succeeds.",
+			},
+			"wait_for_merge": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Wait until the merge request is merged, limited by the create and update timeouts. Fails if the merge request is closed.",
+			},
+			"iid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The IID of the merge request.",
+			},
+			"web_url": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The web URL of the merge request.",
+			},
+			"sha": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The head commit of the merge request.",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The state of the merge request, `opened`, `merged`, `closed` or `locked`.",
+			},
+			"pipeline_status": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The status of the pipeline for the head commit, empty if there is no pipeline.",
+			},
+			"merge_commit_sha": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA of the merge commit, empty until the merge request is merged.",
+			},
+		},
+	}
+}
+
+func resourceGitlabcommitMergeRequestRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	if client.gitlab == nil {
+		return diag.Errorf("gitlabcommit_merge_request requires the %s backend", backendGitlab)
+	}
+	projectId, _ := mergeRequestLocation(d, client)
+
+	if d.Id() == dryRunMergeRequestId {
+		return nil
+	}
+
+	iid, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("invalid merge request ID '%s': %w", d.Id(), err))
+	}
+
+	mr, resp, err := client.gitlab.MergeRequests.GetMergeRequest(projectId, iid, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			logD(fmt.Sprintf("merge request !%d not found, removing from state", iid))
+			d.SetId("")
+			return nil
+		}
+		return diag.FromErr(fmt.Errorf("unable to get merge request !%d: %w", iid, err))
+	}
+
+	d.Set("project_id", projectId)
+	d.Set("source_branch", mr.SourceBranch)
+	d.Set("target_branch", mr.TargetBranch)
+	d.Set("title", mr.Title)
+	d.Set("description", mr.Description)
+	d.Set("iid", mr.IID)
+	d.Set("web_url", mr.WebURL)
+	d.Set("sha", mr.SHA)
+	d.Set("state", mr.State)
+	d.Set("merge_commit_sha", mr.MergeCommitSHA)
+	d.Set("pipeline_status", pipelineStatus(mr))
+
+	return nil
+}
+
+func resourceGitlabcommitMergeRequestCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	if client.gitlab == nil {
+		return diag.Errorf("gitlabcommit_merge_request requires the %s backend", backendGitlab)
+	}
+	projectId, targetBranch := mergeRequestLocation(d, client)
+	sourceBranch := d.Get("source_branch").(string)
+
+	// the request has no actions, it only waits for the batch so the changes are committed before the merge request is opened
+	resp, err := sendRequest(ctx, client, &commitRequest{
+		id:       "merge_request:" + projectId + ":" + sourceBranch + ":" + targetBranch,
+		location: commitLocation{projectId: projectId, branch: sourceBranch},
+	})
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if client.dryRun {
+		// IIDs start at 1, so the ID cannot be mistaken for an existing merge request
+		d.SetId(dryRunMergeRequestId)
+		d.Set("project_id", projectId)
+		d.Set("target_branch", targetBranch)
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  "Merge request not opened in dry run",
+			Detail:   fmt.Sprintf("A merge request from %s into %s would have been opened.", sourceBranch, targetBranch),
+		}}
+	}
+
+	sha, err := headOfBatch(ctx, client, projectId, sourceBranch, resp.commit)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	mr, err := openMergeRequest(ctx, client, projectId, sourceBranch, targetBranch, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(strconv.Itoa(mr.IID))
+	d.Set("project_id", projectId)
+	d.Set("target_branch", targetBranch)
+	d.Set("sha", sha)
+
+	diags := mergeIfRequested(ctx, client, projectId, mr.IID, sha, d, schema.TimeoutCreate)
+	if diags.HasError() {
+		return diags
+	}
+	return append(diags, resourceGitlabcommitMergeRequestRead(ctx, d, meta)...)
+}
+
+func resourceGitlabcommitMergeRequestUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	if client.gitlab == nil {
+		return diag.Errorf("gitlabcommit_merge_request requires the %s backend", backendGitlab)
+	}
+	projectId, _ := mergeRequestLocation(d, client)
+	iid := d.Get("iid").(int)
+
+	// the merge request is not changed in a dry run, so the planned state is kept
+	if client.dryRun {
+		return nil
+	}
+
+	if d.HasChanges("title", "description", "remove_source_branch") {
+		_, _, err := client.gitlab.MergeRequests.UpdateMergeRequest(projectId, iid, &gitlab.UpdateMergeRequestOptions{
+			Title:              gitlab.String(d.Get("title").(string)),
+			Description:        gitlab.String(d.Get("description").(string)),
+			RemoveSourceBranch: gitlab.Bool(d.Get("remove_source_branch").(bool)),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("unable to update merge request !%d: %w", iid, err))
+		}
+	}
+
+	var diags diag.Diagnostics
+	if d.HasChanges("merge_when_pipeline_succeeds", "wait_for_merge") {
+		diags = mergeIfRequested(ctx, client, projectId, iid, d.Get("sha").(string), d, schema.TimeoutUpdate)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	return append(diags, resourceGitlabcommitMergeRequestRead(ctx, d, meta)...)
+}
+
+func resourceGitlabcommitMergeRequestDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*client)
+	if client.gitlab == nil {
+		return diag.Errorf("gitlabcommit_merge_request requires the %s backend", backendGitlab)
+	}
+	projectId, _ := mergeRequestLocation(d, client)
+	iid := d.Get("iid").(int)
+
+	// merged and closed merge requests are kept as history
+	if d.Get("state").(string) == "opened" && !client.dryRun {
+		_, _, err := client.gitlab.MergeRequests.UpdateMergeRequest(projectId, iid, &gitlab.UpdateMergeRequestOptions{
+			StateEvent: gitlab.String("close"),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("unable to close merge request !%d: %w", iid, err))
+		}
+	}
+
+	d.SetId("")
+	return nil
+}
+
+// mergeRequestLocation returns the project and target branch of the merge request, which defaults to the provider configuration
+func mergeRequestLocation(d *schema.ResourceData, client *client) (projectId, targetBranch string) {
+	projectId, targetBranch = client.projectId, client.branch
+	if v := d.Get("project_id").(string); v != "" {
+		projectId = v
+	}
+	if v := d.Get("target_branch").(string); v != "" {
+		targetBranch = v
+	}
+	return projectId, targetBranch
+}
+
+// headOfBatch returns the last commit of the batch, or the head of the branch if the batch did not commit to it
+func headOfBatch(ctx context.Context, client *client, projectId, branch string, commit *gitlab.Commit) (string, error) {
+	if commit != nil {
+		return commit.ID, nil
+	}
+
+	b, err := client.repository.GetBranch(ctx, projectId, branch)
+	if err != nil {
+		return "", fmt.Errorf("unable to get source branch %s: %w", branch, err)
+	}
+	return b.Commit.ID, nil
+}
+
+// openMergeRequest creates the merge request, or takes over an open merge request with the same source and target branch
+func openMergeRequest(ctx context.Context, client *client, projectId, sourceBranch, targetBranch string, d *schema.ResourceData) (*gitlab.MergeRequest, error) {
+	existing, _, err := client.gitlab.MergeRequests.ListProjectMergeRequests(projectId, &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		SourceBranch: gitlab.String(sourceBranch),
+		TargetBranch: gitlab.String(targetBranch),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list merge requests from %s: %w", sourceBranch, err)
+	}
+
+	if len(existing) > 0 {
+		logD(fmt.Sprintf("[RESOURCE] taking over open merge request !%d from %s", existing[0].IID, sourceBranch))
+		mr, _, err := client.gitlab.MergeRequests.UpdateMergeRequest(projectId, existing[0].IID, &gitlab.UpdateMergeRequestOptions{
+			Title:              gitlab.String(d.Get("title").(string)),
+			Description:        gitlab.String(d.Get("description").(string)),
+			RemoveSourceBranch: gitlab.Bool(d.Get("remove_source_branch").(bool)),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("unable to update merge request !%d: %w", existing[0].IID, err)
+		}
+		return mr, nil
+	}
+
+	mr, _, err := client.gitlab.MergeRequests.CreateMergeRequest(projectId, &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.String(d.Get("title").(string)),
+		Description:        gitlab.String(d.Get("description").(string)),
+		SourceBranch:       gitlab.String(sourceBranch),
+		TargetBranch:       gitlab.String(targetBranch),
+		RemoveSourceBranch: gitlab.Bool(d.Get("remove_source_branch").(bool)),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create merge request from %s into %s: %w", sourceBranch, targetBranch, err)
+	}
+	logD(fmt.Sprintf("[RESOURCE] created merge request !%d from %s into %s", mr.IID, sourceBranch, targetBranch))
+	return mr, nil
+}
+
+// mergeIfRequested enables merge when pipeline succeeds and waits for the merge as configured.
+// Failing to enable merge when pipeline succeeds is a warning since the merge request exists, timing out while waiting is an error.
+func mergeIfRequested(ctx context.Context, client *client, projectId string, iid int, sha string, d *schema.ResourceData, timeout string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	if d.Get("merge_when_pipeline_succeeds").(bool) {
+		if err := mergeWhenPipelineSucceeds(ctx, client.gitlab, client.retry, projectId, iid, sha, d.Get("remove_source_branch").(bool)); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Unable to merge merge request !%d when the pipeline succeeds", iid),
+				Detail:   err.Error(),
+			})
+		}
+	}
+
+	if d.Get("wait_for_merge").(bool) {
+		if err := waitForMerge(ctx, client.gitlab, projectId, iid, d.Timeout(timeout), 10*time.Second); err != nil {
+			return append(diags, diag.FromErr(err)...)
+		}
+	}
+
+	return diags
+}
+
+// waitForMerge polls the merge request until it is merged, it fails if the merge request is closed
+func waitForMerge(ctx context.Context, c *gitlab.Client, projectId string, iid int, timeout, pollInterval time.Duration) error {
+	conf := &resource.StateChangeConf{
+		Pending:      []string{"opened", "locked"},
+		Target:       []string{"merged"},
+		Timeout:      timeout,
+		PollInterval: pollInterval,
+		Refresh: func() (interface{}, string, error) {
+			mr, _, err := c.MergeRequests.GetMergeRequest(projectId, iid, nil, gitlab.WithContext(ctx))
+			if err != nil {
+				return nil, "", err
+			}
+			logD(fmt.Sprintf("[RESOURCE] merge request !%d is %s with pipeline status '%s'", iid, mr.State, pipelineStatus(mr)))
+			return mr, mr.State, nil
+		},
+	}
+
+	if _, err := conf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("merge request !%d was not merged: %w", iid, err)
+	}
+	return nil
+}
+
+// pipelineStatus returns the status of the pipeline for the head of the merge request
+func pipelineStatus(mr *gitlab.MergeRequest) string {
+	switch {
+	case mr.HeadPipeline != nil:
+		return mr.HeadPipeline.Status
+	case mr.Pipeline != nil:
+		return mr.Pipeline.Status
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestResourceMergeRequestCreate(t *testing.T) {
+	var created map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/1/merge_requests":
+			fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/1/merge_requests":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			fmt.Fprint(w, `{"iid":7}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/1/merge_requests/7":
+			fmt.Fprint(w, `{"iid":7,"title":"Release","source_branch":"release","target_branch":"main","state":"opened","sha":"abc","web_url":"https://gitlab.example.com/mr/7","head_pipeline":{"status":"running"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	gitlabClient, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+	assert.NoError(t, err)
+
+	var (
+		actionCh = make(chan *commitRequest)
+		c        = &client{gitlab: gitlabClient, repository: newGitlabCommitter(gitlabClient), projectId: "1", branch: "main", actionCh: actionCh}
+	)
+
+	// the merge request waits for the batch committing to the source branch
+	go func() {
+		request := <-actionCh
+		assert.Equal(t, commitLocation{projectId: "1", branch: "release"}, request.location)
+		assert.Empty(t, request.actions)
+		request.reply <- &responseSync{commit: &gitlab.Commit{ID: "abc"}}
+	}()
+
+	d := schema.TestResourceDataRaw(t, resourceGitlabCommitMergeRequest().Schema, map[string]interface{}{
+		"source_branch": "release",
+		"title":         "Release",
+	})
+	diags := resourceGitlabcommitMergeRequestCreate(context.Background(), d, c)
+	assert.False(t, diags.HasError(), fmt.Sprintf("%+v", diags))
+
+	assert.Equal(t, "Release", created["title"])
+	assert.Equal(t, "release", created["source_branch"])
+	assert.Equal(t, "main", created["target_branch"])
+
+	assert.Equal(t, "7", d.Id())
+	assert.Equal(t, "1", d.Get("project_id"))
+	assert.Equal(t, "main", d.Get("target_branch"))
+	assert.Equal(t, "abc", d.Get("sha"))
+	assert.Equal(t, "opened", d.Get("state"))
+	assert.Equal(t, "running", d.Get("pipeline_status"))
+	assert.Equal(t, "https://gitlab.example.com/mr/7", d.Get("web_url"))
+}
+
+func TestWaitForMerge(t *testing.T) {
+	tests := []struct {
+		name        string
+		states      []string
+		expectedErr bool
+	}{
+		{name: "merged", states: []string{"opened", "opened", "merged"}},
+		{name: "closed", states: []string{"opened", "closed"}, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				polls int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v4/projects/1/merge_requests/7" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				state := tt.states[len(tt.states)-1]
+				if polls < len(tt.states) {
+					state = tt.states[polls]
+				}
+				polls++
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"iid":7,"state":"%s"}`, state)
+			}))
+			defer server.Close()
+
+			c, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL))
+			assert.NoError(t, err)
+
+			err = waitForMerge(context.Background(), c, "1", 7, 10*time.Second, 10*time.Millisecond)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/xanzy/go-gitlab"
+)
+
+// defaultRetryableStatusCodes are retried when retryable_status_codes is not set
+var defaultRetryableStatusCodes = []int{
+	http.StatusConflict,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// retryPolicy decides which failed requests to GitLab are retried and how long to wait in between
+type retryPolicy struct {
+	// maxAttempts includes the first attempt
+	maxAttempts uint
+
+	// minBackoff is the wait after the first attempt, it is doubled for every attempt up to maxBackoff
+	minBackoff time.Duration
+
+	maxBackoff time.Duration
+
+	// jitter is the largest random duration added to the backoff
+	jitter time.Duration
+
+	statusCodes map[int]bool
+}
+
+// newRetryPolicy reads the retry block of the provider, the defaults are used if the block is not set
+func newRetryPolicy(d *schema.ResourceData) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts: 5,
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+		jitter:      500 * time.Millisecond,
+		statusCodes: statusCodeSet(defaultRetryableStatusCodes),
+	}
+
+	blocks := d.Get("retry").([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		return policy
+	}
+	block := blocks[0].(map[string]interface{})
+
+	policy.maxAttempts = uint(block["max_attempts"].(int))
+	policy.minBackoff = time.Duration(block["min_backoff"].(int)) * time.Millisecond
+	policy.maxBackoff = time.Duration(block["max_backoff"].(int)) * time.Millisecond
+	policy.jitter = time.Duration(block["jitter"].(int)) * time.Millisecond
+	if statusCodes := toInts(block["retryable_status_codes"]); len(statusCodes) > 0 {
+		policy.statusCodes = statusCodeSet(statusCodes)
+	}
+	return policy
+}
+
+func statusCodeSet(statusCodes []int) map[int]bool {
+	set := map[int]bool{}
+	for _, statusCode := range statusCodes {
+		set[statusCode] = true
+	}
+	return set
+}
+
+// backoff returns the wait after the failed attempt n, counting from zero
+func (p retryPolicy) backoff(n uint) time.Duration {
+	backoff := p.maxBackoff
+	if n < 32 && p.minBackoff<<n < p.maxBackoff {
+		backoff = p.minBackoff << n
+	}
+	if p.jitter > 0 {
+		backoff += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	return backoff
+}
+
+// delay returns the wait after the failed attempt n, the Retry-After header of the response is used if set
+func (p retryPolicy) delay(n uint, resp *http.Response) time.Duration {
+	if resp == nil {
+		return p.backoff(n)
+	}
+	retryAfter := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return p.backoff(n)
+}
+
+// options returns the options to retry with retry-go, stopping when ctx is done. The zero policy makes a single attempt.
+func (p retryPolicy) options(ctx context.Context) []retry.Option {
+	attempts := p.maxAttempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	return []retry.Option{
+		retry.Attempts(attempts),
+		retry.DelayType(func(n uint, _ error, _ *retry.Config) time.Duration {
+			return p.backoff(n)
+		}),
+		retry.LastErrorOnly(true),
+		retry.Context(ctx),
+	}
+}
+
+// idempotentMethods can be sent again after a failure without changing the result
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// ambiguous returns whether the failed request might have been handled by GitLab, e.g. the response to a created commit was lost
+func (p retryPolicy) ambiguous(err error) bool {
+	var errResp *gitlab.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return errResp.Response.StatusCode != http.StatusTooManyRequests && p.statusCodes[errResp.Response.StatusCode]
+	}
+	return transientError(err)
+}
+
+// retryTransport retries the requests failing with a retryable status code or a transient network error.
+// Other requests, e.g. creating a commit, are only retried if GitLab did not handle them, since the failure might have happened after the change was made.
+// The wait between the attempts stops when the context of the request is done.
+type retryTransport struct {
+	next http.RoundTripper
+
+	policy retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body is read once, so it can be sent again
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := req.Context()
+	for attempt := uint(0); ; attempt++ {
+		attemptReq := req
+		if body != nil {
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt+1 >= t.policy.maxAttempts || ctx.Err() != nil || !t.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.policy.delay(attempt, resp)
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			// the connection can only be reused if the body has been read
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("[WARN] %s %s failed with %s, retrying in %s (attempt %d of %d)", req.Method, req.URL.Path, reason, delay, attempt+1, t.policy.maxAttempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (t *retryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !idempotentMethods[req.Method] {
+		// the request did not reach GitLab or was rejected by the rate limit before being handled
+		if err != nil {
+			return errors.Is(err, syscall.ECONNREFUSED)
+		}
+		return resp.StatusCode == http.StatusTooManyRequests && t.policy.statusCodes[resp.StatusCode]
+	}
+	if err != nil {
+		return transientError(err)
+	}
+	return t.policy.statusCodes[resp.StatusCode]
+}
+
+// transientError returns whether the network error might not happen again, e.g. an invalid certificate is not retried
+func transientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
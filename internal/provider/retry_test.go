@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{})
+	assert.Equal(t, retryPolicy{
+		maxAttempts: 5,
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+		jitter:      500 * time.Millisecond,
+		statusCodes: map[int]bool{409: true, 429: true, 502: true, 503: true},
+	}, newRetryPolicy(d))
+
+	d = schema.TestResourceDataRaw(t, New().Schema, map[string]interface{}{
+		"retry": []interface{}{map[string]interface{}{
+			"max_attempts":           3,
+			"min_backoff":            100,
+			"jitter":                 0,
+			"retryable_status_codes": []interface{}{500},
+		}},
+	})
+	assert.Equal(t, retryPolicy{
+		maxAttempts: 3,
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  30 * time.Second,
+		statusCodes: map[int]bool{500: true},
+	}, newRetryPolicy(d))
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{minBackoff: time.Second, maxBackoff: 3 * time.Second}
+	response := func(retryAfter string) *http.Response {
+		resp := &http.Response{Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	assert.Equal(t, time.Second, policy.delay(0, nil))
+	assert.Equal(t, 2*time.Second, policy.delay(1, response("")))
+	assert.Equal(t, 3*time.Second, policy.delay(2, response("")))
+	assert.Equal(t, 3*time.Second, policy.delay(100, response("")))
+	assert.Equal(t, 10*time.Second, policy.delay(0, response("10")))
+	assert.Equal(t, time.Duration(0), policy.delay(0, response(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))))
+	assert.InDelta(t, time.Hour, policy.delay(0, response(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))), float64(2*time.Second))
+
+	policy.jitter = time.Second
+	for i := 0; i < 10; i++ {
+		delay := policy.delay(0, nil)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.Less(t, delay, 2*time.Second)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		statusCodes      []int
+		expectedStatus   int
+		expectedAttempts int
+	}{
+		{
+			name:             "retryable status is retried",
+			method:           http.MethodPut,
+			statusCodes:      []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			expectedStatus:   http.StatusOK,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "other status is not retried",
+			method:           http.MethodPut,
+			statusCodes:      []int{http.StatusBadRequest, http.StatusOK},
+			expectedStatus:   http.StatusBadRequest,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "last response is returned after max attempts",
+			method:           http.MethodPut,
+			statusCodes:      []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			expectedStatus:   http.StatusBadGateway,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "rate limited post is retried",
+			method:           http.MethodPost,
+			statusCodes:      []int{http.StatusTooManyRequests, http.StatusCreated},
+			expectedStatus:   http.StatusCreated,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "post is not retried after it might have been handled",
+			method:           http.MethodPost,
+			statusCodes:      []int{http.StatusBadGateway, http.StatusCreated},
+			expectedStatus:   http.StatusBadGateway,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				attempts int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				// the body is sent with every attempt
+				body, _ := io.ReadAll(r.Body)
+				assert.Equal(t, "payload", string(body))
+
+				w.WriteHeader(tt.statusCodes[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			httpClient := &http.Client{Transport: &retryTransport{
+				next: http.DefaultTransport,
+				policy: retryPolicy{
+					maxAttempts: 3,
+					minBackoff:  time.Millisecond,
+					maxBackoff:  time.Millisecond,
+					statusCodes: statusCodeSet(defaultRetryableStatusCodes),
+				},
+			}}
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader("payload"))
+			assert.NoError(t, err)
+			resp, err := httpClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tt.expectedAttempts, attempts)
+		})
+	}
+}
+
+func TestRetryTransportContextCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: &retryTransport{
+		next:   http.DefaultTransport,
+		policy: retryPolicy{maxAttempts: 5, statusCodes: statusCodeSet(defaultRetryableStatusCodes)},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = httpClient.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second, "the wait given by Retry-After should stop when the context is done")
+}